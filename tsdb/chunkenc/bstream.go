@@ -0,0 +1,177 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The code in this file was largely written by Damian Gryski as part of
+// https://github.com/dgryski/go-tsz and is published under the license below.
+// It was modified to accommodate reading from byte slices without modifying
+// the underlying bytes, which would panic when reading from mmap'd
+// read-only byte slices.
+
+package chunkenc
+
+import "io"
+
+// bstream is a stream of bits.
+type bstream struct {
+	stream []byte // the data stream
+	count  uint8  // how many bits are valid in current byte
+}
+
+func (b *bstream) bytes() []byte {
+	return b.stream
+}
+
+type bit bool
+
+const (
+	zero bit = false
+	one  bit = true
+)
+
+func (b *bstream) writeBit(bit bit) {
+	if b.count == 0 {
+		b.stream = append(b.stream, 0)
+		b.count = 8
+	}
+
+	i := len(b.stream) - 1
+
+	if bit {
+		b.stream[i] |= 1 << (b.count - 1)
+	}
+
+	b.count--
+}
+
+func (b *bstream) writeByte(byt byte) {
+	if b.count == 0 {
+		b.stream = append(b.stream, 0)
+		b.count = 8
+	}
+
+	i := len(b.stream) - 1
+
+	// Complete the last byte with the leftmost b.count bits from byt.
+	b.stream[i] |= byt >> (8 - b.count)
+
+	b.stream = append(b.stream, 0)
+	i++
+	b.stream[i] = byt << b.count
+}
+
+// writeBits writes the nbits right-most bits of u to the stream
+// in left-to-right order.
+func (b *bstream) writeBits(u uint64, nbits int) {
+	u <<= 64 - uint(nbits)
+	for nbits >= 8 {
+		byt := byte(u >> 56)
+		b.writeByte(byt)
+		u <<= 8
+		nbits -= 8
+	}
+
+	for nbits > 0 {
+		b.writeBit((u >> 63) == 1)
+		u <<= 1
+		nbits--
+	}
+}
+
+// bstreamReader reads bits from a bstream's underlying byte slice without
+// mutating it, so it is safe to use over memory-mapped (read-only) chunks.
+type bstreamReader struct {
+	stream []byte
+	pos    int // byte position in stream for the next read
+
+	buffer uint64 // bits not yet consumed, left-aligned within the low `valid` bits
+	valid  uint8  // number of valid (unconsumed) bits in buffer
+}
+
+func newBReader(b []byte) bstreamReader {
+	return bstreamReader{stream: b}
+}
+
+func (b *bstreamReader) readBit() (bit, error) {
+	if b.valid == 0 {
+		if !b.loadNextBuffer(1) {
+			return false, io.EOF
+		}
+	}
+	b.valid--
+	return (b.buffer>>b.valid)&1 == 1, nil
+}
+
+func (b *bstreamReader) readBits(nbits uint8) (uint64, error) {
+	if b.valid == 0 {
+		if !b.loadNextBuffer(nbits) {
+			return 0, io.EOF
+		}
+	}
+
+	if nbits <= b.valid {
+		bitmask := (uint64(1) << nbits) - 1
+		b.valid -= nbits
+		return (b.buffer >> b.valid) & bitmask, nil
+	}
+
+	// Not enough bits buffered: consume what we have and reload for the rest.
+	bitmask := (uint64(1) << b.valid) - 1
+	v := (b.buffer & bitmask) << (nbits - b.valid)
+	nbits -= b.valid
+	b.valid = 0
+
+	if !b.loadNextBuffer(nbits) {
+		return 0, io.EOF
+	}
+
+	bitmask = (uint64(1) << nbits) - 1
+	v |= (b.buffer >> (b.valid - nbits)) & bitmask
+	b.valid -= nbits
+
+	return v, nil
+}
+
+func (b *bstreamReader) ReadByte() (byte, error) {
+	v, err := b.readBits(8)
+	return byte(v), err
+}
+
+// loadNextBuffer loads at least nbits into the buffer, reading whole bytes
+// from the underlying stream.
+func (b *bstreamReader) loadNextBuffer(nbits uint8) bool {
+	if b.pos >= len(b.stream) {
+		return false
+	}
+	if nbits < 8 {
+		nbits = 8
+	}
+
+	// Round up to a whole number of bytes: a floor division here would
+	// load fewer bits than requested whenever nbits isn't a multiple of
+	// 8, which underflows b.valid-nbits in readBits.
+	nbytes := int((nbits + 7) / 8)
+	if b.pos+nbytes > len(b.stream) {
+		nbytes = len(b.stream) - b.pos
+	}
+
+	buffer := uint64(0)
+	for i := 0; i < nbytes; i++ {
+		buffer = buffer<<8 | uint64(b.stream[b.pos+i])
+	}
+
+	b.buffer = buffer
+	b.valid = uint8(nbytes * 8)
+	b.pos += nbytes
+
+	return true
+}
@@ -0,0 +1,270 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chunkenc
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Encoding is the identifier for a chunk encoding.
+type Encoding uint8
+
+// The different available chunk encodings.
+const (
+	EncNone Encoding = iota
+	EncXOR
+	EncHistogram
+	EncFloatHistogram
+	EncIntXOR
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case EncNone:
+		return "none"
+	case EncXOR:
+		return "XOR"
+	case EncHistogram:
+		return "histogram"
+	case EncFloatHistogram:
+		return "floathistogram"
+	case EncIntXOR:
+		return "intXOR"
+	}
+	return "<unknown>"
+}
+
+// Chunk holds a sequence of sample pairs that can be iterated over and
+// appended to.
+type Chunk interface {
+	// Bytes returns the underlying byte slice of the chunk.
+	Bytes() []byte
+
+	// Encoding returns the encoding type of the chunk.
+	Encoding() Encoding
+
+	// Appender returns an appender to append samples to the chunk.
+	Appender() (Appender, error)
+
+	// Iterator returns an iterator over the sample values of the chunk.
+	// The iterator passed as argument is for re-use. Depending on the
+	// implementation, the iterator can be re-used or a new iterator can
+	// be allocated.
+	Iterator(Iterator) Iterator
+
+	// NumSamples returns the number of samples in the chunk.
+	NumSamples() int
+
+	// Compact is called whenever a chunk is expected to be complete (no
+	// more samples appended) and the underlying implementation can
+	// eventually optimize the chunk.
+	Compact()
+
+	// Reset resets the chunk given the bytes and the boundaries.
+	Reset(stream []byte)
+}
+
+// Appender adds sample pairs to a chunk.
+type Appender interface {
+	Append(int64, float64)
+}
+
+// ValueType defines the type of a value an Iterator points to.
+type ValueType int
+
+// Available value types.
+const (
+	ValNone ValueType = iota
+	ValFloat
+	ValHistogram
+	ValFloatHistogram
+)
+
+func (v ValueType) String() string {
+	switch v {
+	case ValNone:
+		return "none"
+	case ValFloat:
+		return "float"
+	case ValHistogram:
+		return "histogram"
+	case ValFloatHistogram:
+		return "floathistogram"
+	}
+	return "unknown"
+}
+
+// Iterator is a simple iterator that can get the next or previous value.
+// Iterator iterates over the samples of a time series, in time-increasing order.
+type Iterator interface {
+	// Next advances the iterator by one and returns the type of the value
+	// at the new position (or ValNone if the iterator is exhausted).
+	Next() ValueType
+	// Seek advances the iterator forward to the first sample with a
+	// timestamp equal or greater than t. If the current sample found by a
+	// previous `Next` or `Seek` operation already has this property, Seek
+	// has no effect. If a sample has been found, Seek returns the type of
+	// its value. Otherwise, it returns ValNone, after which the iterator is
+	// exhausted.
+	Seek(t int64) ValueType
+	// Prev moves the iterator back by one and returns the type of the
+	// value at the new position. If there is no earlier sample, Prev
+	// returns ValNone and leaves the iterator positioned before the first
+	// sample, so that a subsequent Next returns the first sample again.
+	Prev() ValueType
+	// SeekBack moves the iterator backward to the last sample with a
+	// timestamp equal or less than t. If the current sample already has
+	// this property, SeekBack has no effect. If a sample has been found,
+	// SeekBack returns the type of its value. Otherwise, it returns
+	// ValNone and leaves the iterator positioned before the first sample.
+	SeekBack(t int64) ValueType
+	// At returns the current timestamp/value pair if the value is a float.
+	// Before the iterator has advanced, the behaviour is unspecified.
+	At() (int64, float64)
+	// Err returns the current error.
+	Err() error
+}
+
+// checkpointInterval is how often, in samples, iterators that support
+// Prev and SeekBack record a decoder checkpoint: the bit-reader position
+// plus enough decoder state to resume forward decoding from there. Prev
+// and SeekBack rewind to the nearest checkpoint at or before the target
+// sample and replay forward with Next, rather than keeping every sample's
+// state or re-decoding from the start of the chunk on every call.
+const checkpointInterval = 16
+
+// Pool is used to create and reuse chunk references to avoid allocations.
+type Pool interface {
+	Get(e Encoding, b []byte) (Chunk, error)
+	Put(Chunk) error
+}
+
+// pool is a memory pool of chunk objects.
+type pool struct {
+	xor            sync.Pool
+	histogram      sync.Pool
+	floatHistogram sync.Pool
+	intXOR         sync.Pool
+}
+
+// NewPool returns a new pool.
+func NewPool() Pool {
+	return &pool{
+		xor: sync.Pool{
+			New: func() interface{} {
+				return &XORChunk{b: bstream{stream: make([]byte, 0, 128)}}
+			},
+		},
+		histogram: sync.Pool{
+			New: func() interface{} {
+				return &HistogramChunk{b: bstream{stream: make([]byte, 0, 128)}}
+			},
+		},
+		floatHistogram: sync.Pool{
+			New: func() interface{} {
+				return &FloatHistogramChunk{b: bstream{stream: make([]byte, 0, 128)}}
+			},
+		},
+		intXOR: sync.Pool{
+			New: func() interface{} {
+				return &IntXORChunk{b: bstream{stream: make([]byte, 0, 128)}}
+			},
+		},
+	}
+}
+
+func (p *pool) Get(e Encoding, b []byte) (Chunk, error) {
+	switch e {
+	case EncXOR:
+		c := p.xor.Get().(*XORChunk)
+		c.b.stream = b
+		c.b.count = 0
+		return c, nil
+	case EncHistogram:
+		c := p.histogram.Get().(*HistogramChunk)
+		c.b.stream = b
+		c.b.count = 0
+		return c, nil
+	case EncFloatHistogram:
+		c := p.floatHistogram.Get().(*FloatHistogramChunk)
+		c.b.stream = b
+		c.b.count = 0
+		return c, nil
+	case EncIntXOR:
+		c := p.intXOR.Get().(*IntXORChunk)
+		c.b.stream = b
+		c.b.count = 0
+		return c, nil
+	}
+	return nil, fmt.Errorf("invalid chunk encoding %q", e)
+}
+
+func (p *pool) Put(c Chunk) error {
+	switch c.Encoding() {
+	case EncXOR:
+		xc, ok := c.(*XORChunk)
+		// This may happen often with wrapped chunks. Nothing we can really do about
+		// it but returning an error would cause a lot of allocations again. Thus,
+		// we just skip it.
+		if !ok {
+			return nil
+		}
+		xc.b.stream = nil
+		xc.b.count = 0
+		p.xor.Put(xc)
+	case EncHistogram:
+		hc, ok := c.(*HistogramChunk)
+		if !ok {
+			return nil
+		}
+		hc.b.stream = nil
+		hc.b.count = 0
+		p.histogram.Put(hc)
+	case EncFloatHistogram:
+		hc, ok := c.(*FloatHistogramChunk)
+		if !ok {
+			return nil
+		}
+		hc.b.stream = nil
+		hc.b.count = 0
+		p.floatHistogram.Put(hc)
+	case EncIntXOR:
+		ic, ok := c.(*IntXORChunk)
+		if !ok {
+			return nil
+		}
+		ic.b.stream = nil
+		ic.b.count = 0
+		p.intXOR.Put(ic)
+	default:
+		return fmt.Errorf("invalid chunk encoding %q", c.Encoding())
+	}
+	return nil
+}
+
+// NewEmptyChunk returns a new chunk for the given encoding.
+func NewEmptyChunk(e Encoding) (Chunk, error) {
+	switch e {
+	case EncXOR:
+		return NewXORChunk(), nil
+	case EncHistogram:
+		return NewHistogramChunk(), nil
+	case EncFloatHistogram:
+		return NewFloatHistogramChunk(), nil
+	case EncIntXOR:
+		return NewIntXORChunk(), nil
+	}
+	return nil, fmt.Errorf("invalid chunk encoding %q", e)
+}
@@ -17,6 +17,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"testing"
 
@@ -28,9 +29,20 @@ type pair struct {
 	v float64
 }
 
+type hpair struct {
+	t int64
+	h *Histogram
+}
+
+type fhpair struct {
+	t int64
+	h *FloatHistogram
+}
+
 func TestChunk(t *testing.T) {
 	for enc, nc := range map[Encoding]func() Chunk{
-		EncXOR: func() Chunk { return NewXORChunk() },
+		EncXOR:    func() Chunk { return NewXORChunk() },
+		EncIntXOR: func() Chunk { return NewIntXORChunk() },
 	} {
 		t.Run(fmt.Sprintf("%v", enc), func(t *testing.T) {
 			for range make([]struct{}, 1) {
@@ -108,6 +120,668 @@ func testChunk(t *testing.T, c Chunk) {
 	require.NoError(t, it3.Err())
 	require.Equal(t, exp[mid:], res3)
 	require.Equal(t, ValNone, it3.Seek(exp[len(exp)-1].t+1))
+
+	// 4. Walk the iterator fully forward, then fully backward via Prev,
+	// and check the backward walk matches the reverse of exp.
+	it4 := c.Iterator(nil)
+	var fwd4 []pair
+	for it4.Next() == ValFloat {
+		ts, v := it4.At()
+		fwd4 = append(fwd4, pair{t: ts, v: v})
+	}
+	require.NoError(t, it4.Err())
+	require.Equal(t, exp, fwd4)
+
+	var back4 []pair
+	ts, v = it4.At()
+	back4 = append(back4, pair{t: ts, v: v})
+	for it4.Prev() == ValFloat {
+		ts, v := it4.At()
+		back4 = append(back4, pair{t: ts, v: v})
+	}
+	require.NoError(t, it4.Err())
+	require.Equal(t, reverse(exp), back4)
+	require.Equal(t, ValNone, it4.Prev())
+
+	// 5. Test iterator SeekBack, mirroring the Seek block above.
+	it5 := c.Iterator(nil)
+	var res5 []pair
+	require.Equal(t, ValFloat, it5.Seek(exp[len(exp)-1].t))
+	require.Equal(t, ValFloat, it5.SeekBack(exp[mid].t))
+	// Below ones should not matter.
+	require.Equal(t, ValFloat, it5.SeekBack(exp[mid].t))
+	require.Equal(t, ValFloat, it5.SeekBack(exp[mid].t))
+	ts, v = it5.At()
+	res5 = append(res5, pair{t: ts, v: v})
+
+	for it5.Prev() == ValFloat {
+		ts, v := it5.At()
+		res5 = append(res5, pair{t: ts, v: v})
+	}
+	require.NoError(t, it5.Err())
+	require.Equal(t, reverse(exp[:mid+1]), res5)
+	require.Equal(t, ValNone, it5.SeekBack(exp[0].t-1))
+}
+
+// reverse returns a copy of in with its elements in reverse order.
+func reverse(in []pair) []pair {
+	out := make([]pair, len(in))
+	for i, p := range in {
+		out[len(in)-1-i] = p
+	}
+	return out
+}
+
+// TestIntXORChunk exercises the parts of IntXORChunk that testChunk can't:
+// an all-integer series (no fallback at all) and a series that starts out
+// integer-valued and then has to fall back to XOR partway through.
+func TestIntXORChunk(t *testing.T) {
+	t.Run("all integers", func(t *testing.T) {
+		c := NewIntXORChunk()
+		app, err := c.Appender()
+		require.NoError(t, err)
+
+		var exp []pair
+		ts := int64(1234123324)
+		v := 1243535.0
+		for i := 0; i < 300; i++ {
+			ts += int64(rand.Intn(10000) + 1)
+			if i%2 == 0 {
+				v += float64(rand.Intn(1000000))
+			} else {
+				v -= float64(rand.Intn(1000000))
+			}
+			app.Append(ts, v)
+			exp = append(exp, pair{t: ts, v: v})
+		}
+
+		it := c.Iterator(nil)
+		var res []pair
+		for it.Next() == ValFloat {
+			ts, v := it.At()
+			res = append(res, pair{t: ts, v: v})
+		}
+		require.NoError(t, it.Err())
+		require.Equal(t, exp, res)
+
+		// Walk the iterator fully forward, then fully backward via Prev, to
+		// exercise the int delta-of-delta checkpoint/restore path (testChunk's
+		// shared fixture never produces integer-valued samples, so it can't
+		// cover this for IntXORChunk).
+		it2 := c.Iterator(nil)
+		var fwd2 []pair
+		for it2.Next() == ValFloat {
+			ts, v := it2.At()
+			fwd2 = append(fwd2, pair{t: ts, v: v})
+		}
+		require.NoError(t, it2.Err())
+		require.Equal(t, exp, fwd2)
+
+		var back2 []pair
+		ts, v = it2.At()
+		back2 = append(back2, pair{t: ts, v: v})
+		for it2.Prev() == ValFloat {
+			ts, v := it2.At()
+			back2 = append(back2, pair{t: ts, v: v})
+		}
+		require.NoError(t, it2.Err())
+		require.Equal(t, reverse(exp), back2)
+		require.Equal(t, ValNone, it2.Prev())
+
+		// Mirror testChunk's SeekBack block.
+		mid := len(exp) / 2
+		it3 := c.Iterator(nil)
+		var res3 []pair
+		require.Equal(t, ValFloat, it3.Seek(exp[len(exp)-1].t))
+		require.Equal(t, ValFloat, it3.SeekBack(exp[mid].t))
+		ts, v = it3.At()
+		res3 = append(res3, pair{t: ts, v: v})
+
+		for it3.Prev() == ValFloat {
+			ts, v := it3.At()
+			res3 = append(res3, pair{t: ts, v: v})
+		}
+		require.NoError(t, it3.Err())
+		require.Equal(t, reverse(exp[:mid+1]), res3)
+		require.Equal(t, ValNone, it3.SeekBack(exp[0].t-1))
+	})
+
+	t.Run("falls back to XOR mid-chunk", func(t *testing.T) {
+		c := NewIntXORChunk()
+		app, err := c.Appender()
+		require.NoError(t, err)
+
+		exp := []pair{
+			{t: 100, v: 1},
+			{t: 200, v: 2},
+			{t: 300, v: 3},
+			{t: 400, v: 3.5}, // First non-integer sample triggers the fallback.
+			{t: 500, v: 3.75},
+		}
+		for _, p := range exp {
+			app.Append(p.t, p.v)
+		}
+
+		it := c.Iterator(nil)
+		var res []pair
+		for it.Next() == ValFloat {
+			ts, v := it.At()
+			res = append(res, pair{t: ts, v: v})
+		}
+		require.NoError(t, it.Err())
+		require.Equal(t, exp, res)
+
+		// Appending further samples after the chunk has been re-opened via
+		// a fresh appender should remain in (and stay correct in) XOR mode.
+		app2, err := c.Appender()
+		require.NoError(t, err)
+		app2.Append(600, 4.125)
+		exp = append(exp, pair{t: 600, v: 4.125})
+
+		it2 := c.Iterator(nil)
+		var res2 []pair
+		for it2.Next() == ValFloat {
+			ts, v := it2.At()
+			res2 = append(res2, pair{t: ts, v: v})
+		}
+		require.NoError(t, it2.Err())
+		require.Equal(t, exp, res2)
+	})
+}
+
+// TestXORChunkAppendChunk checks that AppendChunk splices one chunk onto
+// another so that the merged iterator yields the concatenation of both.
+func TestXORChunkAppendChunk(t *testing.T) {
+	buildChunk := func(start int64, n int) (Chunk, []pair) {
+		c := NewXORChunk()
+		app, err := c.Appender()
+		require.NoError(t, err)
+
+		var exp []pair
+		ts := start
+		v := 100.0
+		for i := 0; i < n; i++ {
+			ts += int64(rand.Intn(10000) + 1)
+			v += float64(rand.Intn(1000)) - 500.12
+			app.Append(ts, v)
+			exp = append(exp, pair{t: ts, v: v})
+		}
+		return c, exp
+	}
+
+	expand := func(c Chunk) []pair {
+		var res []pair
+		it := c.Iterator(nil)
+		for it.Next() == ValFloat {
+			ts, v := it.At()
+			res = append(res, pair{t: ts, v: v})
+		}
+		require.NoError(t, it.Err())
+		return res
+	}
+
+	t.Run("splices onto a non-empty chunk", func(t *testing.T) {
+		a, expA := buildChunk(1000, 150)
+		b, expB := buildChunk(expA[len(expA)-1].t+1, 150)
+
+		leftover, err := a.(Merger).AppendChunk(b)
+		require.NoError(t, err)
+		require.Nil(t, leftover)
+
+		require.Equal(t, append(append([]pair{}, expA...), expB...), expand(a))
+	})
+
+	t.Run("splices onto an empty chunk", func(t *testing.T) {
+		var a Chunk = NewXORChunk()
+		b, expB := buildChunk(1000, 150)
+
+		leftover, err := a.(Merger).AppendChunk(b)
+		require.NoError(t, err)
+		require.Nil(t, leftover)
+
+		require.Equal(t, expB, expand(a))
+	})
+
+	t.Run("falls back when capacity is exhausted", func(t *testing.T) {
+		a, expA := buildChunk(1000, int(math.MaxUint16)-1)
+		b, expB := buildChunk(expA[len(expA)-1].t+1, 10)
+
+		leftover, err := a.(Merger).AppendChunk(b)
+		require.NoError(t, err)
+		require.NotNil(t, leftover)
+
+		got := append(expand(a), expand(leftover)...)
+		require.Equal(t, append(append([]pair{}, expA...), expB...), got)
+	})
+}
+
+// TestHistogramChunkAppendChunk mirrors TestXORChunkAppendChunk for
+// HistogramChunk, which splices through the same spliceBits seam logic.
+func TestHistogramChunkAppendChunk(t *testing.T) {
+	buildChunk := func(start int64, n int, schema int32) (Chunk, []hpair) {
+		c := NewHistogramChunk()
+		app, err := c.Appender()
+		require.NoError(t, err)
+		ha := app.(*histogramAppender)
+
+		var exp []hpair
+		ts := start
+		count := uint64(100)
+		for i := 0; i < n; i++ {
+			ts += int64(rand.Intn(10000) + 1)
+			count += uint64(rand.Intn(1000))
+			h := &Histogram{
+				Schema:        schema,
+				ZeroThreshold: 0.001,
+				ZeroCount:     count / 10,
+				Count:         count,
+				Sum:           float64(count) * 1.5,
+				Buckets:       []int64{int64(count / 4), int64(count / 8), int64(count / 8)},
+			}
+			ha.AppendHistogram(ts, h)
+			exp = append(exp, hpair{t: ts, h: h})
+		}
+		return c, exp
+	}
+
+	expand := func(c Chunk) []hpair {
+		var res []hpair
+		it := c.Iterator(nil).(HistogramIterator)
+		for it.Next() == ValHistogram {
+			ts, h := it.AtHistogram()
+			res = append(res, hpair{t: ts, h: h.Copy()})
+		}
+		require.NoError(t, it.Err())
+		return res
+	}
+
+	t.Run("splices onto a non-empty chunk", func(t *testing.T) {
+		a, expA := buildChunk(1000, 150, 1)
+		b, expB := buildChunk(expA[len(expA)-1].t+1, 150, 1)
+
+		leftover, err := a.(Merger).AppendChunk(b)
+		require.NoError(t, err)
+		require.Nil(t, leftover)
+
+		require.Equal(t, append(append([]hpair{}, expA...), expB...), expand(a))
+	})
+
+	t.Run("splices onto an empty chunk", func(t *testing.T) {
+		var a Chunk = NewHistogramChunk()
+		b, expB := buildChunk(1000, 150, 1)
+
+		leftover, err := a.(Merger).AppendChunk(b)
+		require.NoError(t, err)
+		require.Nil(t, leftover)
+
+		require.Equal(t, expB, expand(a))
+	})
+
+	t.Run("falls back on a schema change", func(t *testing.T) {
+		// A schema change makes the seam unsafe to splice, so this takes
+		// the sample-by-sample path; since a has room for all of b's
+		// samples, everything still ends up in a and leftover is nil.
+		a, expA := buildChunk(1000, 150, 1)
+		b, expB := buildChunk(expA[len(expA)-1].t+1, 10, 2)
+
+		leftover, err := a.(Merger).AppendChunk(b)
+		require.NoError(t, err)
+		require.Nil(t, leftover)
+
+		require.Equal(t, append(append([]hpair{}, expA...), expB...), expand(a))
+	})
+
+	t.Run("falls back when capacity is exhausted", func(t *testing.T) {
+		a, expA := buildChunk(1000, int(math.MaxUint16)-1, 1)
+		b, expB := buildChunk(expA[len(expA)-1].t+1, 10, 1)
+
+		leftover, err := a.(Merger).AppendChunk(b)
+		require.NoError(t, err)
+		require.NotNil(t, leftover)
+
+		got := append(expand(a), expand(leftover)...)
+		require.Equal(t, append(append([]hpair{}, expA...), expB...), got)
+	})
+}
+
+// TestFloatHistogramChunkAppendChunk mirrors TestXORChunkAppendChunk for
+// FloatHistogramChunk, which splices through the same spliceBits seam
+// logic as XORChunk and HistogramChunk.
+func TestFloatHistogramChunkAppendChunk(t *testing.T) {
+	buildChunk := func(start int64, n int, schema int32) (Chunk, []fhpair) {
+		c := NewFloatHistogramChunk()
+		app, err := c.Appender()
+		require.NoError(t, err)
+		ha := app.(*floatHistogramAppender)
+
+		var exp []fhpair
+		ts := start
+		count := 100.0
+		for i := 0; i < n; i++ {
+			ts += int64(rand.Intn(10000) + 1)
+			count += float64(rand.Intn(1000))
+			h := &FloatHistogram{
+				Schema:        schema,
+				ZeroThreshold: 0.001,
+				ZeroCount:     count / 10,
+				Count:         count,
+				Sum:           count * 1.5,
+				Buckets:       []float64{count / 4, count / 8, count / 8},
+			}
+			ha.AppendFloatHistogram(ts, h)
+			exp = append(exp, fhpair{t: ts, h: h})
+		}
+		return c, exp
+	}
+
+	expand := func(c Chunk) []fhpair {
+		var res []fhpair
+		it := c.Iterator(nil).(FloatHistogramIterator)
+		for it.Next() == ValFloatHistogram {
+			ts, h := it.AtFloatHistogram()
+			res = append(res, fhpair{t: ts, h: h.Copy()})
+		}
+		require.NoError(t, it.Err())
+		return res
+	}
+
+	t.Run("splices onto a non-empty chunk", func(t *testing.T) {
+		a, expA := buildChunk(1000, 150, 1)
+		b, expB := buildChunk(expA[len(expA)-1].t+1, 150, 1)
+
+		leftover, err := a.(Merger).AppendChunk(b)
+		require.NoError(t, err)
+		require.Nil(t, leftover)
+
+		require.Equal(t, append(append([]fhpair{}, expA...), expB...), expand(a))
+	})
+
+	t.Run("splices onto an empty chunk", func(t *testing.T) {
+		var a Chunk = NewFloatHistogramChunk()
+		b, expB := buildChunk(1000, 150, 1)
+
+		leftover, err := a.(Merger).AppendChunk(b)
+		require.NoError(t, err)
+		require.Nil(t, leftover)
+
+		require.Equal(t, expB, expand(a))
+	})
+
+	t.Run("falls back on a schema change", func(t *testing.T) {
+		// A schema change makes the seam unsafe to splice, so this takes
+		// the sample-by-sample path; since a has room for all of b's
+		// samples, everything still ends up in a and leftover is nil.
+		a, expA := buildChunk(1000, 150, 1)
+		b, expB := buildChunk(expA[len(expA)-1].t+1, 10, 2)
+
+		leftover, err := a.(Merger).AppendChunk(b)
+		require.NoError(t, err)
+		require.Nil(t, leftover)
+
+		require.Equal(t, append(append([]fhpair{}, expA...), expB...), expand(a))
+	})
+
+	t.Run("falls back when capacity is exhausted", func(t *testing.T) {
+		a, expA := buildChunk(1000, int(math.MaxUint16)-1, 1)
+		b, expB := buildChunk(expA[len(expA)-1].t+1, 10, 1)
+
+		leftover, err := a.(Merger).AppendChunk(b)
+		require.NoError(t, err)
+		require.NotNil(t, leftover)
+
+		got := append(expand(a), expand(leftover)...)
+		require.Equal(t, append(append([]fhpair{}, expA...), expB...), got)
+	})
+}
+
+// reverseHistograms returns a copy of in with its elements in reverse order.
+func reverseHistograms(in []hpair) []hpair {
+	out := make([]hpair, len(in))
+	for i, p := range in {
+		out[len(in)-1-i] = p
+	}
+	return out
+}
+
+// TestHistogramChunkReverseIteration mirrors testChunk's Prev/SeekBack
+// phases for HistogramChunk, which testChunk itself never exercises.
+func TestHistogramChunkReverseIteration(t *testing.T) {
+	c := NewHistogramChunk()
+	app, err := c.Appender()
+	require.NoError(t, err)
+	ha := app.(*histogramAppender)
+
+	var exp []hpair
+	ts := int64(1234123324)
+	count := uint64(100)
+	for i := 0; i < 300; i++ {
+		ts += int64(rand.Intn(10000) + 1)
+		count += uint64(rand.Intn(1000))
+		h := &Histogram{
+			Schema:        1,
+			ZeroThreshold: 0.001,
+			ZeroCount:     count / 10,
+			Count:         count,
+			Sum:           float64(count) * 1.5,
+			Buckets:       []int64{int64(count / 4), int64(count / 8), int64(count / 8)},
+		}
+		ha.AppendHistogram(ts, h)
+		exp = append(exp, hpair{t: ts, h: h})
+	}
+
+	// Walk fully forward, then fully backward via Prev, and check the
+	// backward walk matches the reverse of exp.
+	it := c.Iterator(nil).(HistogramIterator)
+	var fwd []hpair
+	for it.Next() == ValHistogram {
+		ts, h := it.AtHistogram()
+		fwd = append(fwd, hpair{t: ts, h: h.Copy()})
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, exp, fwd)
+
+	var back []hpair
+	ts, h := it.AtHistogram()
+	back = append(back, hpair{t: ts, h: h.Copy()})
+	for it.Prev() == ValHistogram {
+		ts, h := it.AtHistogram()
+		back = append(back, hpair{t: ts, h: h.Copy()})
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, reverseHistograms(exp), back)
+	require.Equal(t, ValNone, it.Prev())
+
+	// Test SeekBack, mirroring the Seek block in testChunk.
+	mid := len(exp) / 2
+	it2 := c.Iterator(nil).(HistogramIterator)
+	var res []hpair
+	require.Equal(t, ValHistogram, it2.Seek(exp[len(exp)-1].t))
+	require.Equal(t, ValHistogram, it2.SeekBack(exp[mid].t))
+	ts, h = it2.AtHistogram()
+	res = append(res, hpair{t: ts, h: h.Copy()})
+
+	for it2.Prev() == ValHistogram {
+		ts, h := it2.AtHistogram()
+		res = append(res, hpair{t: ts, h: h.Copy()})
+	}
+	require.NoError(t, it2.Err())
+	require.Equal(t, reverseHistograms(exp[:mid+1]), res)
+	require.Equal(t, ValNone, it2.SeekBack(exp[0].t-1))
+}
+
+// reverseFloatHistograms returns a copy of in with its elements in reverse
+// order.
+func reverseFloatHistograms(in []fhpair) []fhpair {
+	out := make([]fhpair, len(in))
+	for i, p := range in {
+		out[len(in)-1-i] = p
+	}
+	return out
+}
+
+// TestFloatHistogramChunkReverseIteration mirrors testChunk's Prev/SeekBack
+// phases for FloatHistogramChunk, which testChunk itself never exercises.
+func TestFloatHistogramChunkReverseIteration(t *testing.T) {
+	c := NewFloatHistogramChunk()
+	app, err := c.Appender()
+	require.NoError(t, err)
+	ha := app.(*floatHistogramAppender)
+
+	var exp []fhpair
+	ts := int64(1234123324)
+	count := 100.0
+	for i := 0; i < 300; i++ {
+		ts += int64(rand.Intn(10000) + 1)
+		count += float64(rand.Intn(1000))
+		h := &FloatHistogram{
+			Schema:        1,
+			ZeroThreshold: 0.001,
+			ZeroCount:     count / 10,
+			Count:         count,
+			Sum:           count * 1.5,
+			Buckets:       []float64{count / 4, count / 8, count / 8},
+		}
+		ha.AppendFloatHistogram(ts, h)
+		exp = append(exp, fhpair{t: ts, h: h})
+	}
+
+	it := c.Iterator(nil).(FloatHistogramIterator)
+	var fwd []fhpair
+	for it.Next() == ValFloatHistogram {
+		ts, h := it.AtFloatHistogram()
+		fwd = append(fwd, fhpair{t: ts, h: h.Copy()})
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, exp, fwd)
+
+	var back []fhpair
+	ts, h := it.AtFloatHistogram()
+	back = append(back, fhpair{t: ts, h: h.Copy()})
+	for it.Prev() == ValFloatHistogram {
+		ts, h := it.AtFloatHistogram()
+		back = append(back, fhpair{t: ts, h: h.Copy()})
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, reverseFloatHistograms(exp), back)
+	require.Equal(t, ValNone, it.Prev())
+
+	mid := len(exp) / 2
+	it2 := c.Iterator(nil).(FloatHistogramIterator)
+	var res []fhpair
+	require.Equal(t, ValFloatHistogram, it2.Seek(exp[len(exp)-1].t))
+	require.Equal(t, ValFloatHistogram, it2.SeekBack(exp[mid].t))
+	ts, h = it2.AtFloatHistogram()
+	res = append(res, fhpair{t: ts, h: h.Copy()})
+
+	for it2.Prev() == ValFloatHistogram {
+		ts, h := it2.AtFloatHistogram()
+		res = append(res, fhpair{t: ts, h: h.Copy()})
+	}
+	require.NoError(t, it2.Err())
+	require.Equal(t, reverseFloatHistograms(exp[:mid+1]), res)
+	require.Equal(t, ValNone, it2.SeekBack(exp[0].t-1))
+}
+
+func BenchmarkXORChunkAppendChunk(b *testing.B) {
+	newPair := func(start int64) (Chunk, Chunk) {
+		mk := func(from int64) Chunk {
+			c := NewXORChunk()
+			app, _ := c.Appender()
+			t, v := from, 1000.0
+			for i := 0; i < 120; i++ {
+				t += 15000
+				v += 1
+				app.Append(t, v)
+			}
+			return c
+		}
+		return mk(start), mk(start + 120*15000)
+	}
+
+	b.Run("splice", func(b *testing.B) {
+		pairs := make([][2]Chunk, b.N)
+		for i := range pairs {
+			c1, c2 := newPair(int64(i) * 120 * 15000 * 2)
+			pairs[i] = [2]Chunk{c1, c2}
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for _, p := range pairs {
+			if _, err := p[0].(Merger).AppendChunk(p[1]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("re-append", func(b *testing.B) {
+		pairs := make([][2]Chunk, b.N)
+		for i := range pairs {
+			c1, c2 := newPair(int64(i) * 120 * 15000 * 2)
+			pairs[i] = [2]Chunk{c1, c2}
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for _, p := range pairs {
+			app, _ := p[0].Appender()
+			it := p[1].Iterator(nil)
+			for it.Next() == ValFloat {
+				t, v := it.At()
+				app.Append(t, v)
+			}
+		}
+	})
+}
+
+// BenchmarkXORChunkPrev compares walking a chunk backward with Prev, which
+// rewinds to the nearest checkpoint and replays forward, against a naive
+// baseline that has no backward iteration support at all: for every step
+// back, it builds a fresh iterator and re-decodes from the start of the
+// chunk.
+func BenchmarkXORChunkPrev(b *testing.B) {
+	const samplesPerChunk = 1000
+
+	newChunk := func() Chunk {
+		c := NewXORChunk()
+		app, _ := c.Appender()
+		t, v := int64(1234123324), 1243535.123
+		for i := 0; i < samplesPerChunk; i++ {
+			t += 1000
+			v++
+			app.Append(t, v)
+		}
+		return c
+	}
+
+	b.Run("Prev", func(b *testing.B) {
+		c := newChunk()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			it := c.Iterator(nil)
+			for it.Next() != ValNone {
+			}
+			for it.Prev() != ValNone {
+			}
+		}
+	})
+
+	b.Run("naive rewind from start", func(b *testing.B) {
+		c := newChunk()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for target := samplesPerChunk - 1; target >= 0; target-- {
+				it := c.Iterator(nil)
+				for j := 0; j <= target; j++ {
+					if it.Next() == ValNone {
+						b.Fatal("unexpected end of chunk")
+					}
+				}
+			}
+		}
+	})
 }
 
 func TestPool(t *testing.T) {
@@ -129,6 +803,10 @@ func TestPool(t *testing.T) {
 			name:     "float histogram",
 			encoding: EncFloatHistogram,
 		},
+		{
+			name:     "int xor",
+			encoding: EncIntXOR,
+		},
 		{
 			name:     "invalid encoding",
 			encoding: EncNone,
@@ -150,6 +828,8 @@ func TestPool(t *testing.T) {
 				b = &c.(*HistogramChunk).b
 			case EncFloatHistogram:
 				b = &c.(*FloatHistogramChunk).b
+			case EncIntXOR:
+				b = &c.(*IntXORChunk).b
 			default:
 				b = &c.(*XORChunk).b
 			}
@@ -280,6 +960,63 @@ func BenchmarkXORAppender(b *testing.B) {
 	})
 }
 
+func newIntXORChunk() Chunk {
+	return NewIntXORChunk()
+}
+
+func BenchmarkIntXORIterator(b *testing.B) {
+	benchmarkIterator(b, newIntXORChunk)
+}
+
+func BenchmarkIntXORAppender(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	b.Run("constant", func(b *testing.B) {
+		benchmarkIntAppender(b, func() (int64, float64) {
+			return 1000, 0
+		}, newIntXORChunk)
+	})
+	b.Run("random steps", func(b *testing.B) {
+		benchmarkIntAppender(b, func() (int64, float64) {
+			return int64(r.Intn(100) - 50 + 15000), // 15 seconds +- up to 100ms of jitter.
+				float64(r.Intn(100) - 50) // Varying from -50 to +50 in 100 discrete steps.
+		}, newIntXORChunk)
+	})
+}
+
+// benchmarkIntAppender mirrors benchmarkAppender but starts from an
+// integer-valued base, so that the "constant"/"random steps" workloads
+// stay on IntXORChunk's int delta-of-delta fast path instead of
+// immediately falling back to XOR value encoding on the first sample.
+func benchmarkIntAppender(b *testing.B, deltas func() (int64, float64), newChunk func() Chunk) {
+	var (
+		t = int64(1234123324)
+		v = 1243535.0
+	)
+	const nSamples = 120 // Same as tsdb.DefaultSamplesPerChunk.
+	var exp []pair
+	for i := 0; i < nSamples; i++ {
+		dt, dv := deltas()
+		t += dt
+		v += dv
+		exp = append(exp, pair{t: t, v: v})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c := newChunk()
+
+		a, err := c.Appender()
+		if err != nil {
+			b.Fatalf("get appender: %s", err)
+		}
+		for _, p := range exp {
+			a.Append(p.t, p.v)
+		}
+	}
+}
+
 func benchmarkAppender(b *testing.B, deltas func() (int64, float64), newChunk func() Chunk) {
 	var (
 		t = int64(1234123324)
@@ -0,0 +1,527 @@
+// Copyright 2022 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chunkenc
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// FloatHistogramChunk holds float-histogram encoded sample data. It mirrors
+// HistogramChunk, but since every field of a FloatHistogram is already a
+// float, nothing goes through putVarbitInt except Schema and the bucket
+// count: Sum uses the same XOR value encoding as XORChunk, and
+// ZeroThreshold, ZeroCount, Count and every bucket are written as raw
+// 64-bit floats.
+type FloatHistogramChunk struct {
+	b bstream
+}
+
+// NewFloatHistogramChunk returns a new chunk with float-histogram encoding.
+func NewFloatHistogramChunk() *FloatHistogramChunk {
+	b := make([]byte, 2, 128)
+	return &FloatHistogramChunk{b: bstream{stream: b, count: 0}}
+}
+
+// Encoding implements the Chunk interface.
+func (c *FloatHistogramChunk) Encoding() Encoding {
+	return EncFloatHistogram
+}
+
+// Bytes implements the Chunk interface.
+func (c *FloatHistogramChunk) Bytes() []byte {
+	return c.b.bytes()
+}
+
+// NumSamples implements the Chunk interface.
+func (c *FloatHistogramChunk) NumSamples() int {
+	return int(binary.BigEndian.Uint16(c.Bytes()))
+}
+
+// Compact implements the Chunk interface.
+func (c *FloatHistogramChunk) Compact() {
+	if l := len(c.b.stream); cap(c.b.stream) > l+chunkCompactCapacityThreshold {
+		buf := make([]byte, l)
+		copy(buf, c.b.stream)
+		c.b.stream = buf
+	}
+}
+
+// Reset implements the Chunk interface.
+func (c *FloatHistogramChunk) Reset(b []byte) {
+	c.b.stream = b
+	c.b.count = 0
+}
+
+// Appender implements the Chunk interface. Float-histogram samples are
+// appended via AppendFloatHistogram; Append(t, v) only satisfies the
+// Appender interface and must not be called.
+func (c *FloatHistogramChunk) Appender() (Appender, error) {
+	it := c.iterator(nil)
+	for it.Next() != ValNone {
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	a := &floatHistogramAppender{
+		b:                 &c.b,
+		t:                 it.t,
+		sum:               it.h.Sum,
+		tDelta:            it.tDelta,
+		leading:           it.leading,
+		trailing:          it.trailing,
+		lastSchema:        it.h.Schema,
+		lastZeroThreshold: it.h.ZeroThreshold,
+	}
+	// As in XORChunk.Appender: leading is 0xff until the second sample.
+	if binary.BigEndian.Uint16(a.b.bytes()) < 2 {
+		a.leading = 0xff
+	}
+	return a, nil
+}
+
+func (c *FloatHistogramChunk) iterator(it Iterator) *floatHistogramIterator {
+	if fhIter, ok := it.(*floatHistogramIterator); ok {
+		fhIter.Reset(c.b.bytes())
+		return fhIter
+	}
+	return &floatHistogramIterator{
+		br:       newBReader(c.b.bytes()),
+		numTotal: c.NumSamples(),
+		t:        math.MinInt64,
+		h:        &FloatHistogram{},
+	}
+}
+
+// Iterator implements the Chunk interface.
+func (c *FloatHistogramChunk) Iterator(it Iterator) Iterator {
+	return c.iterator(it)
+}
+
+type floatHistogramAppender struct {
+	b *bstream
+
+	t      int64
+	sum    float64
+	tDelta uint64
+
+	leading  uint8
+	trailing uint8
+
+	// lastSchema and lastZeroThreshold describe the most recently
+	// appended sample, so AppendChunk can tell whether a chunk it is
+	// about to splice onto this one uses a compatible bucket layout.
+	lastSchema        int32
+	lastZeroThreshold float64
+}
+
+// Append must not be called on a float-histogram appender; use
+// AppendFloatHistogram.
+func (a *floatHistogramAppender) Append(int64, float64) {
+	panic("Append called on a floatHistogramAppender; use AppendFloatHistogram")
+}
+
+// AppendFloatHistogram adds a float-histogram sample to the chunk.
+func (a *floatHistogramAppender) AppendFloatHistogram(t int64, h *FloatHistogram) {
+	var tDelta uint64
+	num := binary.BigEndian.Uint16(a.b.bytes())
+
+	switch num {
+	case 0:
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, byt := range buf[:binary.PutVarint(buf, t)] {
+			a.b.writeByte(byt)
+		}
+		a.b.writeBits(math.Float64bits(h.Sum), 64)
+
+	case 1:
+		tDelta = uint64(t - a.t)
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, byt := range buf[:binary.PutUvarint(buf, tDelta)] {
+			a.b.writeByte(byt)
+		}
+		xorWriteValue(a.b, h.Sum, a.sum, &a.leading, &a.trailing)
+
+	default:
+		tDelta = uint64(t - a.t)
+		dod := int64(tDelta - a.tDelta)
+
+		switch {
+		case dod == 0:
+			a.b.writeBit(zero)
+		case bitRange(dod, 14):
+			a.b.writeBits(0b10, 2)
+			a.b.writeBits(uint64(dod), 14)
+		case bitRange(dod, 17):
+			a.b.writeBits(0b110, 3)
+			a.b.writeBits(uint64(dod), 17)
+		case bitRange(dod, 20):
+			a.b.writeBits(0b1110, 4)
+			a.b.writeBits(uint64(dod), 20)
+		default:
+			a.b.writeBits(0b1111, 4)
+			a.b.writeBits(uint64(dod), 64)
+		}
+		xorWriteValue(a.b, h.Sum, a.sum, &a.leading, &a.trailing)
+	}
+
+	a.b.writeBits(uint64(h.CounterResetHint), 2)
+	putVarbitInt(a.b, int64(h.Schema))
+	a.b.writeBits(math.Float64bits(h.ZeroThreshold), 64)
+	a.b.writeBits(math.Float64bits(h.ZeroCount), 64)
+	a.b.writeBits(math.Float64bits(h.Count), 64)
+	putVarbitInt(a.b, int64(len(h.Buckets)))
+	for _, v := range h.Buckets {
+		a.b.writeBits(math.Float64bits(v), 64)
+	}
+
+	a.t = t
+	a.sum = h.Sum
+	a.tDelta = tDelta
+	a.lastSchema = h.Schema
+	a.lastZeroThreshold = h.ZeroThreshold
+	binary.BigEndian.PutUint16(a.b.bytes(), num+1)
+}
+
+type floatHistogramIterator struct {
+	br       bstreamReader
+	numTotal int
+	numRead  int
+
+	t int64
+	h *FloatHistogram
+
+	leading  uint8
+	trailing uint8
+
+	tDelta uint64
+	err    error
+
+	checkpoints []floatHistogramCheckpoint
+}
+
+// floatHistogramCheckpoint is decoder state captured every
+// checkpointInterval samples, see the comment on that constant. As with
+// histogramCheckpoint, h is a full snapshot rather than just Sum: landing
+// exactly on a checkpoint doesn't call Next, so there's no other chance
+// to repopulate Schema, ZeroThreshold and the bucket counts.
+type floatHistogramCheckpoint struct {
+	br      bstreamReader
+	numRead int
+
+	t int64
+	h *FloatHistogram
+
+	leading  uint8
+	trailing uint8
+	tDelta   uint64
+}
+
+func (it *floatHistogramIterator) Seek(t int64) ValueType {
+	if it.err != nil {
+		return ValNone
+	}
+	for t > it.t || it.numRead == 0 {
+		if it.Next() == ValNone {
+			return ValNone
+		}
+	}
+	return ValFloatHistogram
+}
+
+// Prev implements the Iterator interface.
+func (it *floatHistogramIterator) Prev() ValueType {
+	if it.err != nil || it.numRead == 0 {
+		return ValNone
+	}
+	idx := it.numRead - 2
+	if idx < 0 {
+		it.resetDecode()
+		return ValNone
+	}
+	return it.seekToIndex(idx)
+}
+
+// SeekBack implements the Iterator interface.
+func (it *floatHistogramIterator) SeekBack(t int64) ValueType {
+	if it.err != nil {
+		return ValNone
+	}
+	if it.numRead > 0 && it.t <= t {
+		return ValFloatHistogram
+	}
+
+	start := 0
+	for _, cp := range it.checkpoints {
+		if cp.t > t {
+			break
+		}
+		start = cp.numRead - 1
+	}
+	if it.seekToIndex(start) == ValNone {
+		return ValNone
+	}
+	if it.t > t {
+		return it.Prev()
+	}
+
+	last := it.numRead - 1
+	for {
+		if it.Next() == ValNone || it.t > t {
+			break
+		}
+		last = it.numRead - 1
+	}
+	if last != it.numRead-1 {
+		return it.seekToIndex(last)
+	}
+	return ValFloatHistogram
+}
+
+// resetDecode rewinds the iterator to before the first sample.
+func (it *floatHistogramIterator) resetDecode() {
+	it.br = newBReader(it.br.stream)
+	it.numRead = 0
+	it.t = math.MinInt64
+	it.h = &FloatHistogram{}
+	it.leading = 0
+	it.trailing = 0
+	it.tDelta = 0
+	it.err = nil
+}
+
+// seekToIndex moves to the 0-based sample index idx (which must be within
+// [0, numTotal)), restoring the nearest checkpoint at or before idx and
+// replaying forward with Next from there.
+func (it *floatHistogramIterator) seekToIndex(idx int) ValueType {
+	best := -1
+	for i, cp := range it.checkpoints {
+		if cp.numRead-1 > idx {
+			break
+		}
+		best = i
+	}
+	if best >= 0 {
+		cp := it.checkpoints[best]
+		it.br = cp.br
+		it.numRead = cp.numRead
+		it.t = cp.t
+		it.h = cp.h.Copy()
+		it.leading = cp.leading
+		it.trailing = cp.trailing
+		it.tDelta = cp.tDelta
+		it.err = nil
+	} else {
+		it.resetDecode()
+	}
+	for it.numRead-1 < idx {
+		if it.Next() == ValNone {
+			return ValNone
+		}
+	}
+	return ValFloatHistogram
+}
+
+// maybeCheckpoint appends a checkpoint for the sample just decoded, every
+// checkpointInterval samples, unless an earlier pass already recorded it.
+func (it *floatHistogramIterator) maybeCheckpoint() {
+	if it.numRead%checkpointInterval != 0 || len(it.checkpoints) >= it.numRead/checkpointInterval {
+		return
+	}
+	it.checkpoints = append(it.checkpoints, floatHistogramCheckpoint{
+		br:       it.br,
+		numRead:  it.numRead,
+		t:        it.t,
+		h:        it.h.Copy(),
+		leading:  it.leading,
+		trailing: it.trailing,
+		tDelta:   it.tDelta,
+	})
+}
+
+// At implements the Iterator interface.
+func (it *floatHistogramIterator) At() (int64, float64) {
+	return it.t, it.h.Sum
+}
+
+// AtFloatHistogram returns the current timestamp and float-histogram.
+func (it *floatHistogramIterator) AtFloatHistogram() (int64, *FloatHistogram) {
+	return it.t, it.h
+}
+
+func (it *floatHistogramIterator) Err() error {
+	return it.err
+}
+
+func (it *floatHistogramIterator) Reset(b []byte) {
+	it.br = newBReader(b)
+	it.numTotal = int(binary.BigEndian.Uint16(b))
+
+	it.numRead = 0
+	it.t = math.MinInt64
+	it.h = &FloatHistogram{}
+	it.leading = 0
+	it.trailing = 0
+	it.tDelta = 0
+	it.err = nil
+	it.checkpoints = it.checkpoints[:0]
+}
+
+func (it *floatHistogramIterator) Next() ValueType {
+	if it.err != nil || it.numRead == it.numTotal {
+		return ValNone
+	}
+
+	if it.numRead == 0 {
+		if _, err := it.br.ReadByte(); err != nil {
+			it.err = err
+			return ValNone
+		}
+		if _, err := it.br.ReadByte(); err != nil {
+			it.err = err
+			return ValNone
+		}
+		t, err := binary.ReadVarint(&it.br)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		sum, err := it.br.readBits(64)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		it.t = t
+		it.h.Sum = math.Float64frombits(sum)
+	} else if it.numRead == 1 {
+		tDelta, err := binary.ReadUvarint(&it.br)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		it.tDelta = tDelta
+		it.t += int64(it.tDelta)
+
+		if err := xorReadValue(&it.h.Sum, &it.br, &it.leading, &it.trailing); err != nil {
+			it.err = err
+			return ValNone
+		}
+	} else {
+		var d byte
+		for i := 0; i < 4; i++ {
+			d <<= 1
+			bitv, err := it.br.readBit()
+			if err != nil {
+				it.err = err
+				return ValNone
+			}
+			if bitv == zero {
+				break
+			}
+			d |= 1
+		}
+		var sz uint8
+		var dod int64
+		switch d {
+		case 0b0:
+		case 0b10:
+			sz = 14
+		case 0b110:
+			sz = 17
+		case 0b1110:
+			sz = 20
+		case 0b1111:
+			v, err := it.br.readBits(64)
+			if err != nil {
+				it.err = err
+				return ValNone
+			}
+			dod = int64(v)
+		}
+		if sz != 0 {
+			v, err := it.br.readBits(sz)
+			if err != nil {
+				it.err = err
+				return ValNone
+			}
+			if v > (1 << (sz - 1)) {
+				v -= 1 << sz
+			}
+			dod = int64(v)
+		}
+		it.tDelta = uint64(int64(it.tDelta) + dod)
+		it.t += int64(it.tDelta)
+
+		if err := xorReadValue(&it.h.Sum, &it.br, &it.leading, &it.trailing); err != nil {
+			it.err = err
+			return ValNone
+		}
+	}
+
+	hint, err := it.br.readBits(2)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	it.h.CounterResetHint = CounterResetHint(hint)
+
+	schema, err := readVarbitInt(&it.br)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	it.h.Schema = int32(schema)
+
+	zt, err := it.br.readBits(64)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	it.h.ZeroThreshold = math.Float64frombits(zt)
+
+	zc, err := it.br.readBits(64)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	it.h.ZeroCount = math.Float64frombits(zc)
+
+	cnt, err := it.br.readBits(64)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	it.h.Count = math.Float64frombits(cnt)
+
+	n, err := readVarbitInt(&it.br)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	buckets := make([]float64, n)
+	for i := range buckets {
+		v, err := it.br.readBits(64)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		buckets[i] = math.Float64frombits(v)
+	}
+	it.h.Buckets = buckets
+
+	it.numRead++
+	it.maybeCheckpoint()
+	return ValFloatHistogram
+}
@@ -0,0 +1,534 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chunkenc
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// HistogramChunk holds histogram encoded sample data.
+//
+// Like XORChunk, timestamps are delta-of-delta encoded. Everything else
+// about a histogram sample that is inherently integer-valued (the zero
+// bucket count, the total count, and every regular bucket count) is
+// written with putVarbitInt rather than through the float XOR path, since
+// that is both cheaper and exact for values that never carry a fractional
+// part. Only Sum, which is a genuine float, goes through the same XOR
+// value encoding as XORChunk.
+type HistogramChunk struct {
+	b bstream
+}
+
+// NewHistogramChunk returns a new chunk with histogram encoding.
+func NewHistogramChunk() *HistogramChunk {
+	b := make([]byte, 2, 128)
+	return &HistogramChunk{b: bstream{stream: b, count: 0}}
+}
+
+// Encoding implements the Chunk interface.
+func (c *HistogramChunk) Encoding() Encoding {
+	return EncHistogram
+}
+
+// Bytes implements the Chunk interface.
+func (c *HistogramChunk) Bytes() []byte {
+	return c.b.bytes()
+}
+
+// NumSamples implements the Chunk interface.
+func (c *HistogramChunk) NumSamples() int {
+	return int(binary.BigEndian.Uint16(c.Bytes()))
+}
+
+// Compact implements the Chunk interface.
+func (c *HistogramChunk) Compact() {
+	if l := len(c.b.stream); cap(c.b.stream) > l+chunkCompactCapacityThreshold {
+		buf := make([]byte, l)
+		copy(buf, c.b.stream)
+		c.b.stream = buf
+	}
+}
+
+// Reset implements the Chunk interface.
+func (c *HistogramChunk) Reset(b []byte) {
+	c.b.stream = b
+	c.b.count = 0
+}
+
+// Appender implements the Chunk interface. Histogram samples are appended
+// via AppendHistogram; Append(t, v) is only present to satisfy the
+// Appender interface and must not be called.
+func (c *HistogramChunk) Appender() (Appender, error) {
+	it := c.iterator(nil)
+	for it.Next() != ValNone {
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	a := &histogramAppender{
+		b:                 &c.b,
+		t:                 it.t,
+		sum:               it.h.Sum,
+		tDelta:            it.tDelta,
+		leading:           it.leading,
+		trailing:          it.trailing,
+		lastSchema:        it.h.Schema,
+		lastZeroThreshold: it.h.ZeroThreshold,
+	}
+	// As in XORChunk.Appender: leading is 0xff until the second sample.
+	if binary.BigEndian.Uint16(a.b.bytes()) < 2 {
+		a.leading = 0xff
+	}
+	return a, nil
+}
+
+func (c *HistogramChunk) iterator(it Iterator) *histogramIterator {
+	if hIter, ok := it.(*histogramIterator); ok {
+		hIter.Reset(c.b.bytes())
+		return hIter
+	}
+	return &histogramIterator{
+		br:       newBReader(c.b.bytes()),
+		numTotal: c.NumSamples(),
+		t:        math.MinInt64,
+		h:        &Histogram{},
+	}
+}
+
+// Iterator implements the Chunk interface.
+func (c *HistogramChunk) Iterator(it Iterator) Iterator {
+	return c.iterator(it)
+}
+
+type histogramAppender struct {
+	b *bstream
+
+	t      int64
+	sum    float64
+	tDelta uint64
+
+	leading  uint8
+	trailing uint8
+
+	// lastSchema and lastZeroThreshold describe the most recently
+	// appended sample, so AppendChunk can tell whether a chunk it is
+	// about to splice onto this one uses a compatible bucket layout.
+	lastSchema        int32
+	lastZeroThreshold float64
+}
+
+// Append must not be called on a histogram appender; use AppendHistogram.
+func (a *histogramAppender) Append(int64, float64) {
+	panic("Append called on a histogramAppender; use AppendHistogram")
+}
+
+// AppendHistogram adds a histogram sample to the chunk.
+func (a *histogramAppender) AppendHistogram(t int64, h *Histogram) {
+	var tDelta uint64
+	num := binary.BigEndian.Uint16(a.b.bytes())
+
+	switch num {
+	case 0:
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, byt := range buf[:binary.PutVarint(buf, t)] {
+			a.b.writeByte(byt)
+		}
+		a.b.writeBits(math.Float64bits(h.Sum), 64)
+
+	case 1:
+		tDelta = uint64(t - a.t)
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, byt := range buf[:binary.PutUvarint(buf, tDelta)] {
+			a.b.writeByte(byt)
+		}
+		xorWriteValue(a.b, h.Sum, a.sum, &a.leading, &a.trailing)
+
+	default:
+		tDelta = uint64(t - a.t)
+		dod := int64(tDelta - a.tDelta)
+
+		switch {
+		case dod == 0:
+			a.b.writeBit(zero)
+		case bitRange(dod, 14):
+			a.b.writeBits(0b10, 2)
+			a.b.writeBits(uint64(dod), 14)
+		case bitRange(dod, 17):
+			a.b.writeBits(0b110, 3)
+			a.b.writeBits(uint64(dod), 17)
+		case bitRange(dod, 20):
+			a.b.writeBits(0b1110, 4)
+			a.b.writeBits(uint64(dod), 20)
+		default:
+			a.b.writeBits(0b1111, 4)
+			a.b.writeBits(uint64(dod), 64)
+		}
+		xorWriteValue(a.b, h.Sum, a.sum, &a.leading, &a.trailing)
+	}
+
+	a.b.writeBits(uint64(h.CounterResetHint), 2)
+	putVarbitInt(a.b, int64(h.Schema))
+	a.b.writeBits(math.Float64bits(h.ZeroThreshold), 64)
+	putVarbitInt(a.b, int64(h.ZeroCount))
+	putVarbitInt(a.b, int64(h.Count))
+	putVarbitInt(a.b, int64(len(h.Buckets)))
+	for _, v := range h.Buckets {
+		putVarbitInt(a.b, v)
+	}
+
+	a.t = t
+	a.sum = h.Sum
+	a.tDelta = tDelta
+	a.lastSchema = h.Schema
+	a.lastZeroThreshold = h.ZeroThreshold
+	binary.BigEndian.PutUint16(a.b.bytes(), num+1)
+}
+
+type histogramIterator struct {
+	br       bstreamReader
+	numTotal int
+	numRead  int
+
+	t  int64
+	h  *Histogram
+	t0 int64
+
+	leading  uint8
+	trailing uint8
+
+	tDelta uint64
+	err    error
+
+	checkpoints []histogramCheckpoint
+}
+
+// histogramCheckpoint is decoder state captured every checkpointInterval
+// samples, see the comment on that constant. h is a full snapshot rather
+// than just Sum: Schema, ZeroThreshold and the bucket counts are written
+// fresh on every sample rather than relative to a predecessor, so Next
+// would repopulate them regardless of where decoding resumes — but
+// landing exactly on a checkpoint (the common case, since checkpoints sit
+// at sample boundaries) doesn't call Next at all, so the checkpoint has
+// to carry the full sample itself.
+type histogramCheckpoint struct {
+	br      bstreamReader
+	numRead int
+
+	t int64
+	h *Histogram
+
+	leading  uint8
+	trailing uint8
+	tDelta   uint64
+}
+
+func (it *histogramIterator) Seek(t int64) ValueType {
+	if it.err != nil {
+		return ValNone
+	}
+	for t > it.t || it.numRead == 0 {
+		if it.Next() == ValNone {
+			return ValNone
+		}
+	}
+	return ValHistogram
+}
+
+// Prev implements the Iterator interface.
+func (it *histogramIterator) Prev() ValueType {
+	if it.err != nil || it.numRead == 0 {
+		return ValNone
+	}
+	idx := it.numRead - 2
+	if idx < 0 {
+		it.resetDecode()
+		return ValNone
+	}
+	return it.seekToIndex(idx)
+}
+
+// SeekBack implements the Iterator interface.
+func (it *histogramIterator) SeekBack(t int64) ValueType {
+	if it.err != nil {
+		return ValNone
+	}
+	if it.numRead > 0 && it.t <= t {
+		return ValHistogram
+	}
+
+	start := 0
+	for _, cp := range it.checkpoints {
+		if cp.t > t {
+			break
+		}
+		start = cp.numRead - 1
+	}
+	if it.seekToIndex(start) == ValNone {
+		return ValNone
+	}
+	if it.t > t {
+		return it.Prev()
+	}
+
+	last := it.numRead - 1
+	for {
+		if it.Next() == ValNone || it.t > t {
+			break
+		}
+		last = it.numRead - 1
+	}
+	if last != it.numRead-1 {
+		return it.seekToIndex(last)
+	}
+	return ValHistogram
+}
+
+// resetDecode rewinds the iterator to before the first sample.
+func (it *histogramIterator) resetDecode() {
+	it.br = newBReader(it.br.stream)
+	it.numRead = 0
+	it.t = math.MinInt64
+	it.h = &Histogram{}
+	it.leading = 0
+	it.trailing = 0
+	it.tDelta = 0
+	it.err = nil
+}
+
+// seekToIndex moves to the 0-based sample index idx (which must be within
+// [0, numTotal)), restoring the nearest checkpoint at or before idx and
+// replaying forward with Next from there.
+func (it *histogramIterator) seekToIndex(idx int) ValueType {
+	best := -1
+	for i, cp := range it.checkpoints {
+		if cp.numRead-1 > idx {
+			break
+		}
+		best = i
+	}
+	if best >= 0 {
+		cp := it.checkpoints[best]
+		it.br = cp.br
+		it.numRead = cp.numRead
+		it.t = cp.t
+		it.h = cp.h.Copy()
+		it.leading = cp.leading
+		it.trailing = cp.trailing
+		it.tDelta = cp.tDelta
+		it.err = nil
+	} else {
+		it.resetDecode()
+	}
+	for it.numRead-1 < idx {
+		if it.Next() == ValNone {
+			return ValNone
+		}
+	}
+	return ValHistogram
+}
+
+// maybeCheckpoint appends a checkpoint for the sample just decoded, every
+// checkpointInterval samples, unless an earlier pass already recorded it.
+func (it *histogramIterator) maybeCheckpoint() {
+	if it.numRead%checkpointInterval != 0 || len(it.checkpoints) >= it.numRead/checkpointInterval {
+		return
+	}
+	it.checkpoints = append(it.checkpoints, histogramCheckpoint{
+		br:       it.br,
+		numRead:  it.numRead,
+		t:        it.t,
+		h:        it.h.Copy(),
+		leading:  it.leading,
+		trailing: it.trailing,
+		tDelta:   it.tDelta,
+	})
+}
+
+// At implements the Iterator interface. Histogram chunks have no single
+// float value; callers that need the sample must use AtHistogram.
+func (it *histogramIterator) At() (int64, float64) {
+	return it.t, it.h.Sum
+}
+
+// AtHistogram returns the current timestamp and histogram.
+func (it *histogramIterator) AtHistogram() (int64, *Histogram) {
+	return it.t, it.h
+}
+
+func (it *histogramIterator) Err() error {
+	return it.err
+}
+
+func (it *histogramIterator) Reset(b []byte) {
+	it.br = newBReader(b)
+	it.numTotal = int(binary.BigEndian.Uint16(b))
+
+	it.numRead = 0
+	it.t = math.MinInt64
+	it.h = &Histogram{}
+	it.leading = 0
+	it.trailing = 0
+	it.tDelta = 0
+	it.err = nil
+	it.checkpoints = it.checkpoints[:0]
+}
+
+func (it *histogramIterator) Next() ValueType {
+	if it.err != nil || it.numRead == it.numTotal {
+		return ValNone
+	}
+
+	if it.numRead == 0 {
+		if _, err := it.br.ReadByte(); err != nil {
+			it.err = err
+			return ValNone
+		}
+		if _, err := it.br.ReadByte(); err != nil {
+			it.err = err
+			return ValNone
+		}
+		t, err := binary.ReadVarint(&it.br)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		sum, err := it.br.readBits(64)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		it.t = t
+		it.h.Sum = math.Float64frombits(sum)
+	} else if it.numRead == 1 {
+		tDelta, err := binary.ReadUvarint(&it.br)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		it.tDelta = tDelta
+		it.t += int64(it.tDelta)
+
+		if err := xorReadValue(&it.h.Sum, &it.br, &it.leading, &it.trailing); err != nil {
+			it.err = err
+			return ValNone
+		}
+	} else {
+		var d byte
+		for i := 0; i < 4; i++ {
+			d <<= 1
+			bitv, err := it.br.readBit()
+			if err != nil {
+				it.err = err
+				return ValNone
+			}
+			if bitv == zero {
+				break
+			}
+			d |= 1
+		}
+		var sz uint8
+		var dod int64
+		switch d {
+		case 0b0:
+		case 0b10:
+			sz = 14
+		case 0b110:
+			sz = 17
+		case 0b1110:
+			sz = 20
+		case 0b1111:
+			v, err := it.br.readBits(64)
+			if err != nil {
+				it.err = err
+				return ValNone
+			}
+			dod = int64(v)
+		}
+		if sz != 0 {
+			v, err := it.br.readBits(sz)
+			if err != nil {
+				it.err = err
+				return ValNone
+			}
+			if v > (1 << (sz - 1)) {
+				v -= 1 << sz
+			}
+			dod = int64(v)
+		}
+		it.tDelta = uint64(int64(it.tDelta) + dod)
+		it.t += int64(it.tDelta)
+
+		if err := xorReadValue(&it.h.Sum, &it.br, &it.leading, &it.trailing); err != nil {
+			it.err = err
+			return ValNone
+		}
+	}
+
+	hint, err := it.br.readBits(2)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	it.h.CounterResetHint = CounterResetHint(hint)
+
+	schema, err := readVarbitInt(&it.br)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	it.h.Schema = int32(schema)
+
+	zt, err := it.br.readBits(64)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	it.h.ZeroThreshold = math.Float64frombits(zt)
+
+	zc, err := readVarbitInt(&it.br)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	it.h.ZeroCount = uint64(zc)
+
+	cnt, err := readVarbitInt(&it.br)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	it.h.Count = uint64(cnt)
+
+	n, err := readVarbitInt(&it.br)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	buckets := make([]int64, n)
+	for i := range buckets {
+		v, err := readVarbitInt(&it.br)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		buckets[i] = v
+	}
+	it.h.Buckets = buckets
+
+	it.numRead++
+	it.maybeCheckpoint()
+	return ValHistogram
+}
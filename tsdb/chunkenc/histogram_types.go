@@ -0,0 +1,82 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chunkenc
+
+// CounterResetHint describes whether a histogram sample continues the
+// previous one or starts a new counter series, so that consumers (and the
+// chunk's own append/merge logic) don't have to recompute it from the raw
+// bucket counts every time.
+type CounterResetHint byte
+
+const (
+	// UnknownCounterReset means we are in a one-off situation where we
+	// cannot be sure and need to do an expensive bucket comparison.
+	UnknownCounterReset CounterResetHint = iota
+	// CounterReset means there was definitely a counter reset starting
+	// from this sample.
+	CounterReset
+	// NotCounterReset means there was definitely no counter reset with
+	// this sample.
+	NotCounterReset
+	// GaugeType means the series this sample is part of is a gauge
+	// histogram, so counter resets do not apply.
+	GaugeType
+)
+
+// Histogram is an integer histogram sample as carried by a HistogramChunk.
+// It only keeps the fields the chunk encoding needs: a flat, already
+// cumulative-to-delta transformed bucket layout (mirroring how
+// model/histogram.Histogram feeds samples into the chunk in upstream
+// Prometheus), plus the schema/count/sum needed to detect resets.
+type Histogram struct {
+	Schema           int32
+	ZeroThreshold    float64
+	ZeroCount        uint64
+	Count            uint64
+	Sum              float64
+	Buckets          []int64
+	CounterResetHint CounterResetHint
+}
+
+// Copy returns a deep copy of h.
+func (h *Histogram) Copy() *Histogram {
+	c := *h
+	if h.Buckets != nil {
+		c.Buckets = make([]int64, len(h.Buckets))
+		copy(c.Buckets, h.Buckets)
+	}
+	return &c
+}
+
+// FloatHistogram is the float-valued counterpart of Histogram, used by
+// FloatHistogramChunk.
+type FloatHistogram struct {
+	Schema           int32
+	ZeroThreshold    float64
+	ZeroCount        float64
+	Count            float64
+	Sum              float64
+	Buckets          []float64
+	CounterResetHint CounterResetHint
+}
+
+// Copy returns a deep copy of h.
+func (h *FloatHistogram) Copy() *FloatHistogram {
+	c := *h
+	if h.Buckets != nil {
+		c.Buckets = make([]float64, len(h.Buckets))
+		copy(c.Buckets, h.Buckets)
+	}
+	return &c
+}
@@ -0,0 +1,564 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chunkenc
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// IntXORChunk holds XOR-encoded sample data whose values are, so far, all
+// integers. Counters and most gauges round-trip through whole numbers, the
+// same way bucket counts in a HistogramChunk do, so storing values as
+// delta-of-delta zig-zag varints (like timestamps, via putVarbitInt) is
+// both smaller and cheaper than the float XOR path in XORChunk.
+//
+// Should a sample arrive whose value doesn't round to a whole number, the
+// chunk falls back to XOR value encoding (the same scheme as XORChunk) for
+// that sample and every one after it; the sample index of that switch,
+// fallbackAt, is recorded right after the sample count in the chunk's
+// 4-byte header so the iterator knows where to change decoding strategy.
+// A chunk that never sees a non-integer value keeps fallbackAt at its
+// math.MaxUint16 sentinel and is int-encoded throughout.
+type IntXORChunk struct {
+	b bstream
+}
+
+// minInt64Float and maxInt64Float bound the range of float64 values that can
+// be converted to int64 without overflow. Floats at or beyond this magnitude
+// are already spaced further apart than 1, so they satisfy v == math.Trunc(v)
+// despite being outside the int64 range; int64(v) on such a value is an
+// out-of-range conversion, so isInt must reject them and fall back to XOR.
+const (
+	minInt64Float = -float64(1 << 63)
+	maxInt64Float = float64(1 << 63)
+)
+
+// NewIntXORChunk returns a new chunk with int-XOR encoding.
+func NewIntXORChunk() *IntXORChunk {
+	b := make([]byte, 4, 128)
+	binary.BigEndian.PutUint16(b[2:4], math.MaxUint16)
+	return &IntXORChunk{b: bstream{stream: b, count: 0}}
+}
+
+// Encoding implements the Chunk interface.
+func (c *IntXORChunk) Encoding() Encoding {
+	return EncIntXOR
+}
+
+// Bytes implements the Chunk interface.
+func (c *IntXORChunk) Bytes() []byte {
+	return c.b.bytes()
+}
+
+// NumSamples implements the Chunk interface.
+func (c *IntXORChunk) NumSamples() int {
+	return int(binary.BigEndian.Uint16(c.Bytes()))
+}
+
+// Compact implements the Chunk interface.
+func (c *IntXORChunk) Compact() {
+	if l := len(c.b.stream); cap(c.b.stream) > l+chunkCompactCapacityThreshold {
+		buf := make([]byte, l)
+		copy(buf, c.b.stream)
+		c.b.stream = buf
+	}
+}
+
+// Reset implements the Chunk interface.
+func (c *IntXORChunk) Reset(b []byte) {
+	c.b.stream = b
+	c.b.count = 0
+}
+
+// Appender implements the Chunk interface.
+func (c *IntXORChunk) Appender() (Appender, error) {
+	it := c.iterator(nil)
+	for it.Next() != ValNone {
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	a := &intXORAppender{
+		b:           &c.b,
+		t:           it.t,
+		tDelta:      it.tDelta,
+		vInt:        it.vInt,
+		vDelta:      it.vDelta,
+		val:         it.val,
+		leading:     it.leading,
+		trailing:    it.trailing,
+		inFloatMode: it.floatMode,
+	}
+	if binary.BigEndian.Uint16(a.b.bytes()) == 0 {
+		a.leading = 0xff
+	}
+	return a, nil
+}
+
+func (c *IntXORChunk) iterator(it Iterator) *intXORIterator {
+	if ixIter, ok := it.(*intXORIterator); ok {
+		ixIter.Reset(c.b.bytes())
+		return ixIter
+	}
+	return &intXORIterator{
+		br:         newBReader(c.b.bytes()),
+		numTotal:   c.NumSamples(),
+		t:          math.MinInt64,
+		fallbackAt: math.MaxUint16,
+	}
+}
+
+// Iterator implements the Chunk interface.
+func (c *IntXORChunk) Iterator(it Iterator) Iterator {
+	return c.iterator(it)
+}
+
+type intXORAppender struct {
+	b *bstream
+
+	t      int64
+	tDelta uint64
+
+	vInt   int64   // last integer value, valid while inFloatMode is false
+	vDelta int64   // last integer delta, valid while inFloatMode is false
+	val    float64 // last value, as appended, valid in either mode
+
+	leading  uint8
+	trailing uint8
+
+	inFloatMode bool
+}
+
+func (a *intXORAppender) Append(t int64, v float64) {
+	var tDelta uint64
+	num := binary.BigEndian.Uint16(a.b.bytes())
+	isInt := !a.inFloatMode && v == math.Trunc(v) && v >= minInt64Float && v < maxInt64Float
+
+	switch num {
+	case 0:
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, byt := range buf[:binary.PutVarint(buf, t)] {
+			a.b.writeByte(byt)
+		}
+		if isInt {
+			a.vInt = int64(v)
+			putVarbitInt(a.b, a.vInt)
+		} else {
+			a.setFallback(num)
+			a.b.writeBits(math.Float64bits(v), 64)
+		}
+
+	case 1:
+		tDelta = uint64(t - a.t)
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, byt := range buf[:binary.PutUvarint(buf, tDelta)] {
+			a.b.writeByte(byt)
+		}
+		if isInt {
+			a.vDelta = int64(v) - a.vInt
+			a.vInt = int64(v)
+			putVarbitInt(a.b, a.vDelta)
+		} else {
+			a.setFallback(num)
+			xorWriteValue(a.b, v, a.val, &a.leading, &a.trailing)
+		}
+
+	default:
+		tDelta = uint64(t - a.t)
+		dod := int64(tDelta - a.tDelta)
+
+		switch {
+		case dod == 0:
+			a.b.writeBit(zero)
+		case bitRange(dod, 14):
+			a.b.writeBits(0b10, 2)
+			a.b.writeBits(uint64(dod), 14)
+		case bitRange(dod, 17):
+			a.b.writeBits(0b110, 3)
+			a.b.writeBits(uint64(dod), 17)
+		case bitRange(dod, 20):
+			a.b.writeBits(0b1110, 4)
+			a.b.writeBits(uint64(dod), 20)
+		default:
+			a.b.writeBits(0b1111, 4)
+			a.b.writeBits(uint64(dod), 64)
+		}
+
+		if isInt {
+			delta := int64(v) - a.vInt
+			putVarbitInt(a.b, delta-a.vDelta)
+			a.vDelta = delta
+			a.vInt = int64(v)
+		} else {
+			a.setFallback(num)
+			xorWriteValue(a.b, v, a.val, &a.leading, &a.trailing)
+		}
+	}
+
+	a.t = t
+	a.val = v
+	a.tDelta = tDelta
+	binary.BigEndian.PutUint16(a.b.bytes(), num+1)
+}
+
+// setFallback records, the first time a non-integer sample arrives, the
+// index at which XOR value encoding takes over from the int delta-of-delta
+// encoding. It is a no-op once the chunk has already fallen back.
+func (a *intXORAppender) setFallback(atIndex uint16) {
+	if a.inFloatMode {
+		return
+	}
+	a.inFloatMode = true
+	a.leading = 0xff
+	binary.BigEndian.PutUint16(a.b.bytes()[2:4], atIndex)
+}
+
+type intXORIterator struct {
+	br       bstreamReader
+	numTotal int
+	numRead  int
+
+	t          int64
+	tDelta     uint64
+	fallbackAt uint16
+
+	vInt   int64
+	vDelta int64
+	val    float64
+
+	leading   uint8
+	trailing  uint8
+	floatMode bool
+
+	err error
+
+	checkpoints []intXORCheckpoint
+}
+
+// intXORCheckpoint is decoder state captured every checkpointInterval
+// samples, see the comment on that constant.
+type intXORCheckpoint struct {
+	br      bstreamReader
+	numRead int
+
+	t          int64
+	tDelta     uint64
+	fallbackAt uint16
+
+	vInt   int64
+	vDelta int64
+	val    float64
+
+	leading   uint8
+	trailing  uint8
+	floatMode bool
+}
+
+func (it *intXORIterator) Seek(t int64) ValueType {
+	if it.err != nil {
+		return ValNone
+	}
+	for t > it.t || it.numRead == 0 {
+		if it.Next() == ValNone {
+			return ValNone
+		}
+	}
+	return ValFloat
+}
+
+// Prev implements the Iterator interface.
+func (it *intXORIterator) Prev() ValueType {
+	if it.err != nil || it.numRead == 0 {
+		return ValNone
+	}
+	idx := it.numRead - 2
+	if idx < 0 {
+		it.resetDecode()
+		return ValNone
+	}
+	return it.seekToIndex(idx)
+}
+
+// SeekBack implements the Iterator interface.
+func (it *intXORIterator) SeekBack(t int64) ValueType {
+	if it.err != nil {
+		return ValNone
+	}
+	if it.numRead > 0 && it.t <= t {
+		return ValFloat
+	}
+
+	start := 0
+	for _, cp := range it.checkpoints {
+		if cp.t > t {
+			break
+		}
+		start = cp.numRead - 1
+	}
+	if it.seekToIndex(start) == ValNone {
+		return ValNone
+	}
+	if it.t > t {
+		return it.Prev()
+	}
+
+	last := it.numRead - 1
+	for {
+		if it.Next() == ValNone || it.t > t {
+			break
+		}
+		last = it.numRead - 1
+	}
+	if last != it.numRead-1 {
+		return it.seekToIndex(last)
+	}
+	return ValFloat
+}
+
+// resetDecode rewinds the iterator to before the first sample.
+func (it *intXORIterator) resetDecode() {
+	it.br = newBReader(it.br.stream)
+	it.numRead = 0
+	it.t = math.MinInt64
+	it.tDelta = 0
+	it.fallbackAt = math.MaxUint16
+	it.vInt = 0
+	it.vDelta = 0
+	it.val = 0
+	it.leading = 0
+	it.trailing = 0
+	it.floatMode = false
+	it.err = nil
+}
+
+// seekToIndex moves to the 0-based sample index idx (which must be within
+// [0, numTotal)), restoring the nearest checkpoint at or before idx and
+// replaying forward with Next from there.
+func (it *intXORIterator) seekToIndex(idx int) ValueType {
+	best := -1
+	for i, cp := range it.checkpoints {
+		if cp.numRead-1 > idx {
+			break
+		}
+		best = i
+	}
+	if best >= 0 {
+		cp := it.checkpoints[best]
+		it.br = cp.br
+		it.numRead = cp.numRead
+		it.t = cp.t
+		it.tDelta = cp.tDelta
+		it.fallbackAt = cp.fallbackAt
+		it.vInt = cp.vInt
+		it.vDelta = cp.vDelta
+		it.val = cp.val
+		it.leading = cp.leading
+		it.trailing = cp.trailing
+		it.floatMode = cp.floatMode
+		it.err = nil
+	} else {
+		it.resetDecode()
+	}
+	for it.numRead-1 < idx {
+		if it.Next() == ValNone {
+			return ValNone
+		}
+	}
+	return ValFloat
+}
+
+// maybeCheckpoint appends a checkpoint for the sample just decoded, every
+// checkpointInterval samples, unless an earlier pass already recorded it.
+func (it *intXORIterator) maybeCheckpoint() {
+	if it.numRead%checkpointInterval != 0 || len(it.checkpoints) >= it.numRead/checkpointInterval {
+		return
+	}
+	it.checkpoints = append(it.checkpoints, intXORCheckpoint{
+		br:         it.br,
+		numRead:    it.numRead,
+		t:          it.t,
+		tDelta:     it.tDelta,
+		fallbackAt: it.fallbackAt,
+		vInt:       it.vInt,
+		vDelta:     it.vDelta,
+		val:        it.val,
+		leading:    it.leading,
+		trailing:   it.trailing,
+		floatMode:  it.floatMode,
+	})
+}
+
+func (it *intXORIterator) At() (int64, float64) {
+	return it.t, it.val
+}
+
+func (it *intXORIterator) Err() error {
+	return it.err
+}
+
+func (it *intXORIterator) Reset(b []byte) {
+	it.br = newBReader(b)
+	it.numTotal = int(binary.BigEndian.Uint16(b))
+
+	it.numRead = 0
+	it.t = math.MinInt64
+	it.tDelta = 0
+	it.fallbackAt = math.MaxUint16
+	it.vInt = 0
+	it.vDelta = 0
+	it.val = 0
+	it.leading = 0
+	it.trailing = 0
+	it.floatMode = false
+	it.err = nil
+	it.checkpoints = it.checkpoints[:0]
+}
+
+func (it *intXORIterator) Next() ValueType {
+	if it.err != nil || it.numRead == it.numTotal {
+		return ValNone
+	}
+
+	if it.numRead == 0 {
+		if _, err := it.br.ReadByte(); err != nil {
+			it.err = err
+			return ValNone
+		}
+		if _, err := it.br.ReadByte(); err != nil {
+			it.err = err
+			return ValNone
+		}
+		fb, err := it.br.readBits(16)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		it.fallbackAt = uint16(fb)
+
+		t, err := binary.ReadVarint(&it.br)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		it.t = t
+
+		if it.fallbackAt == 0 {
+			it.floatMode = true
+			v, err := it.br.readBits(64)
+			if err != nil {
+				it.err = err
+				return ValNone
+			}
+			it.val = math.Float64frombits(v)
+		} else {
+			vi, err := readVarbitInt(&it.br)
+			if err != nil {
+				it.err = err
+				return ValNone
+			}
+			it.vInt = vi
+			it.val = float64(vi)
+		}
+		it.numRead++
+		it.maybeCheckpoint()
+		return ValFloat
+	}
+
+	if it.numRead == 1 {
+		tDelta, err := binary.ReadUvarint(&it.br)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		it.tDelta = tDelta
+		it.t += int64(it.tDelta)
+	} else {
+		var d byte
+		for i := 0; i < 4; i++ {
+			d <<= 1
+			bitv, err := it.br.readBit()
+			if err != nil {
+				it.err = err
+				return ValNone
+			}
+			if bitv == zero {
+				break
+			}
+			d |= 1
+		}
+		var sz uint8
+		var dod int64
+		switch d {
+		case 0b0:
+		case 0b10:
+			sz = 14
+		case 0b110:
+			sz = 17
+		case 0b1110:
+			sz = 20
+		case 0b1111:
+			v, err := it.br.readBits(64)
+			if err != nil {
+				it.err = err
+				return ValNone
+			}
+			dod = int64(v)
+		}
+		if sz != 0 {
+			v, err := it.br.readBits(sz)
+			if err != nil {
+				it.err = err
+				return ValNone
+			}
+			if v > (1 << (sz - 1)) {
+				v -= 1 << sz
+			}
+			dod = int64(v)
+		}
+		it.tDelta = uint64(int64(it.tDelta) + dod)
+		it.t += int64(it.tDelta)
+	}
+
+	if uint16(it.numRead) >= it.fallbackAt {
+		it.floatMode = true
+		if err := xorReadValue(&it.val, &it.br, &it.leading, &it.trailing); err != nil {
+			it.err = err
+			return ValNone
+		}
+	} else if it.numRead == 1 {
+		delta, err := readVarbitInt(&it.br)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		it.vDelta = delta
+		it.vInt += delta
+		it.val = float64(it.vInt)
+	} else {
+		dod, err := readVarbitInt(&it.br)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		it.vDelta += dod
+		it.vInt += it.vDelta
+		it.val = float64(it.vInt)
+	}
+
+	it.numRead++
+	it.maybeCheckpoint()
+	return ValFloat
+}
@@ -0,0 +1,374 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chunkenc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Merger is implemented by chunks that can splice another chunk covering
+// an adjacent time range directly onto themselves, rather than requiring
+// the caller to iterate the other chunk and re-append every sample.
+//
+// AppendChunk recomputes only the seam: the first two samples of other
+// have to be re-encoded relative to the receiver. The first sample is
+// re-encoded relative to the receiver's last sample as usual. The second
+// also needs re-encoding rather than a verbatim splice, because every
+// appender gives a chunk's second-ever sample a special encoding — a
+// uvarint timestamp delta plus a *forced* full leading/trailing XOR
+// window, see xorAppender.Append's "case 1" — and that forced rewrite is
+// what pins the encoder's leading/trailing state to an exact, known
+// value afterwards. other's third sample was encoded expecting that
+// exact value; re-appending the second sample the ordinary way would let
+// the window-reuse heuristic in xorWriteValue silently keep whatever
+// leading/trailing the receiver already had instead, desynchronizing the
+// decoder before the splice even starts. So the second sample is
+// re-appended with the leading/trailing state reset to the same sentinel
+// an appender starts with, forcing the identical full rewrite other used.
+// From other's third sample on, its bitstream is already in the general
+// delta-of-delta form every chunk uses past that point, so those bits
+// are copied onto the receiver verbatim.
+//
+// When splicing isn't safe — the receiver doesn't have room for all of
+// other's samples, or other is an IntXORChunk appended to an XORChunk, or
+// (for histograms) its layout is incompatible with the receiver's last
+// sample — AppendChunk falls back to the sample-by-sample path and returns
+// a non-nil leftover chunk holding whatever samples didn't fit. A nil
+// leftover with a nil error means every sample of other ended up in the
+// receiver. Other cross-encoding merges (a histogram chunk with a float
+// chunk, or a HistogramChunk with a FloatHistogramChunk) have no fallback:
+// AppendChunk returns an error and leaves the receiver unchanged.
+//
+// IntXORChunk implements Merger too, but always through the sample-by-sample
+// path: its bitstream's int/float-fallback split point makes a checkpoint-free
+// splice unsafe to reason about, so there's no fast path to fall back from.
+type Merger interface {
+	AppendChunk(other Chunk) (leftover Chunk, err error)
+}
+
+// HistogramIterator is implemented by iterators over histogram chunks.
+type HistogramIterator interface {
+	Iterator
+	AtHistogram() (int64, *Histogram)
+}
+
+// FloatHistogramIterator is implemented by iterators over float-histogram
+// chunks.
+type FloatHistogramIterator interface {
+	Iterator
+	AtFloatHistogram() (int64, *FloatHistogram)
+}
+
+// spliceBits copies every remaining bit of br, from its current position to
+// the end of its underlying stream, onto b without decoding any of it. The
+// last padBits bits of the stream are the unused tail of its final byte and
+// are not copied, so a later append to b follows the last spliced sample.
+func spliceBits(b *bstream, br *bstreamReader, padBits uint8) error {
+	remaining := len(br.stream)*8 - int(padBits) - (br.pos*8 - int(br.valid))
+	for remaining > 0 {
+		n := 64
+		if remaining < n {
+			n = remaining
+		}
+		v, err := br.readBits(uint8(n))
+		if err != nil {
+			return err
+		}
+		b.writeBits(v, n)
+		remaining -= n
+	}
+	return nil
+}
+
+// AppendChunk implements Merger.
+func (c *XORChunk) AppendChunk(o Chunk) (Chunk, error) {
+	other, ok := o.(*XORChunk)
+	if !ok {
+		if _, ok := o.(*IntXORChunk); !ok {
+			return nil, fmt.Errorf("cannot append chunk of encoding %s to an XORChunk", o.Encoding())
+		}
+		return appendChunkGenericFloat(c, o)
+	}
+	if other.NumSamples() == 0 {
+		return nil, nil
+	}
+	if c.NumSamples()+other.NumSamples() > math.MaxUint16 {
+		return appendChunkGenericFloat(c, other)
+	}
+
+	oit := other.iterator(nil)
+	if oit.Next() == ValNone {
+		return nil, oit.Err()
+	}
+	t0, v0 := oit.At()
+
+	app, err := c.Appender()
+	if err != nil {
+		return nil, err
+	}
+	xa := app.(*xorAppender)
+	xa.Append(t0, v0)
+	spliced := 1
+
+	if other.NumSamples() >= 2 {
+		if oit.Next() == ValNone {
+			return nil, oit.Err()
+		}
+		t1, v1 := oit.At()
+		// Force the same full XOR-window rewrite other's own encoder was
+		// forced into for its second sample, so xa.leading/xa.trailing end
+		// up exactly where the spliced third sample expects them.
+		xa.leading = 0xff
+		xa.Append(t1, v1)
+		spliced = 2
+	}
+
+	if err := spliceBits(xa.b, &oit.br, other.b.count); err != nil {
+		return nil, err
+	}
+	num := binary.BigEndian.Uint16(xa.b.bytes())
+	binary.BigEndian.PutUint16(xa.b.bytes(), num+uint16(other.NumSamples()-spliced))
+
+	return nil, nil
+}
+
+// AppendChunk implements Merger. Unlike XORChunk, IntXORChunk never splices:
+// it always takes the sample-by-sample path.
+func (c *IntXORChunk) AppendChunk(o Chunk) (Chunk, error) {
+	switch o.(type) {
+	case *IntXORChunk, *XORChunk:
+		return appendChunkGenericFloat(c, o)
+	default:
+		return nil, fmt.Errorf("cannot append chunk of encoding %s to an IntXORChunk", o.Encoding())
+	}
+}
+
+// appendChunkGenericFloat is the sample-by-sample fallback for float-valued
+// chunks (XORChunk and IntXORChunk can both land here: on a type mismatch,
+// on capacity exhaustion, or for any other reason a splice isn't safe).
+func appendChunkGenericFloat(dst, src Chunk) (Chunk, error) {
+	dstApp, err := dst.Appender()
+	if err != nil {
+		return nil, err
+	}
+	leftover, err := NewEmptyChunk(dst.Encoding())
+	if err != nil {
+		return nil, err
+	}
+	leftApp, err := leftover.Appender()
+	if err != nil {
+		return nil, err
+	}
+
+	full := false
+	it := src.Iterator(nil)
+	for it.Next() != ValNone {
+		t, v := it.At()
+		if !full && dst.NumSamples() < math.MaxUint16 {
+			dstApp.Append(t, v)
+			continue
+		}
+		full = true
+		leftApp.Append(t, v)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if !full {
+		return nil, nil
+	}
+	return leftover, nil
+}
+
+// AppendChunk implements Merger.
+func (c *HistogramChunk) AppendChunk(o Chunk) (Chunk, error) {
+	other, ok := o.(*HistogramChunk)
+	if !ok {
+		return nil, fmt.Errorf("cannot append chunk of encoding %s to a HistogramChunk", o.Encoding())
+	}
+	if other.NumSamples() == 0 {
+		return nil, nil
+	}
+	if c.NumSamples()+other.NumSamples() > math.MaxUint16 {
+		return appendChunkGenericHistogram(c, other)
+	}
+
+	oit := other.iterator(nil)
+	if oit.Next() == ValNone {
+		return nil, oit.Err()
+	}
+	t0, h0 := oit.AtHistogram()
+
+	app, err := c.Appender()
+	if err != nil {
+		return nil, err
+	}
+	ha := app.(*histogramAppender)
+
+	// A schema or zero-threshold change means the bucket layout other's
+	// samples were encoded against doesn't match the receiver's, so the
+	// seam sample can't be safely re-encoded by splicing either: fall all
+	// the way back.
+	if c.NumSamples() > 0 && (h0.Schema != ha.lastSchema || h0.ZeroThreshold != ha.lastZeroThreshold) {
+		return appendChunkGenericHistogram(c, other)
+	}
+
+	ha.AppendHistogram(t0, h0)
+	spliced := 1
+
+	if other.NumSamples() >= 2 {
+		if oit.Next() == ValNone {
+			return nil, oit.Err()
+		}
+		t1, h1 := oit.AtHistogram()
+		// Force the same full XOR-window rewrite other's own encoder was
+		// forced into for its second sample, so ha.leading/ha.trailing end
+		// up exactly where the spliced third sample expects them.
+		ha.leading = 0xff
+		ha.AppendHistogram(t1, h1)
+		spliced = 2
+	}
+
+	if err := spliceBits(ha.b, &oit.br, other.b.count); err != nil {
+		return nil, err
+	}
+	num := binary.BigEndian.Uint16(ha.b.bytes())
+	binary.BigEndian.PutUint16(ha.b.bytes(), num+uint16(other.NumSamples()-spliced))
+
+	return nil, nil
+}
+
+func appendChunkGenericHistogram(dst, src *HistogramChunk) (Chunk, error) {
+	dstApp, err := dst.Appender()
+	if err != nil {
+		return nil, err
+	}
+	ha := dstApp.(*histogramAppender)
+
+	leftover := NewHistogramChunk()
+	leftApp, err := leftover.Appender()
+	if err != nil {
+		return nil, err
+	}
+	la := leftApp.(*histogramAppender)
+
+	full := false
+	it := src.iterator(nil)
+	for it.Next() != ValNone {
+		t, h := it.AtHistogram()
+		if !full && dst.NumSamples() < math.MaxUint16 {
+			ha.AppendHistogram(t, h)
+			continue
+		}
+		full = true
+		la.AppendHistogram(t, h)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if !full {
+		return nil, nil
+	}
+	return leftover, nil
+}
+
+// AppendChunk implements Merger.
+func (c *FloatHistogramChunk) AppendChunk(o Chunk) (Chunk, error) {
+	other, ok := o.(*FloatHistogramChunk)
+	if !ok {
+		return nil, fmt.Errorf("cannot append chunk of encoding %s to a FloatHistogramChunk", o.Encoding())
+	}
+	if other.NumSamples() == 0 {
+		return nil, nil
+	}
+	if c.NumSamples()+other.NumSamples() > math.MaxUint16 {
+		return appendChunkGenericFloatHistogram(c, other)
+	}
+
+	oit := other.iterator(nil)
+	if oit.Next() == ValNone {
+		return nil, oit.Err()
+	}
+	t0, h0 := oit.AtFloatHistogram()
+
+	app, err := c.Appender()
+	if err != nil {
+		return nil, err
+	}
+	ha := app.(*floatHistogramAppender)
+
+	if c.NumSamples() > 0 && (h0.Schema != ha.lastSchema || h0.ZeroThreshold != ha.lastZeroThreshold) {
+		return appendChunkGenericFloatHistogram(c, other)
+	}
+
+	ha.AppendFloatHistogram(t0, h0)
+	spliced := 1
+
+	if other.NumSamples() >= 2 {
+		if oit.Next() == ValNone {
+			return nil, oit.Err()
+		}
+		t1, h1 := oit.AtFloatHistogram()
+		// Force the same full XOR-window rewrite other's own encoder was
+		// forced into for its second sample, so ha.leading/ha.trailing end
+		// up exactly where the spliced third sample expects them.
+		ha.leading = 0xff
+		ha.AppendFloatHistogram(t1, h1)
+		spliced = 2
+	}
+
+	if err := spliceBits(ha.b, &oit.br, other.b.count); err != nil {
+		return nil, err
+	}
+	num := binary.BigEndian.Uint16(ha.b.bytes())
+	binary.BigEndian.PutUint16(ha.b.bytes(), num+uint16(other.NumSamples()-spliced))
+
+	return nil, nil
+}
+
+func appendChunkGenericFloatHistogram(dst, src *FloatHistogramChunk) (Chunk, error) {
+	dstApp, err := dst.Appender()
+	if err != nil {
+		return nil, err
+	}
+	ha := dstApp.(*floatHistogramAppender)
+
+	leftover := NewFloatHistogramChunk()
+	leftApp, err := leftover.Appender()
+	if err != nil {
+		return nil, err
+	}
+	la := leftApp.(*floatHistogramAppender)
+
+	full := false
+	it := src.iterator(nil)
+	for it.Next() != ValNone {
+		t, h := it.AtFloatHistogram()
+		if !full && dst.NumSamples() < math.MaxUint16 {
+			ha.AppendFloatHistogram(t, h)
+			continue
+		}
+		full = true
+		la.AppendFloatHistogram(t, h)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if !full {
+		return nil, nil
+	}
+	return leftover, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chunkenc
+
+// putVarbitInt writes val to b as a zig-zag encoded, variable-length
+// integer: 7 bits of payload per byte with the 8th bit signalling whether
+// another byte follows. It is used for anything that is "integer but
+// unbounded", like histogram bucket counts and their deltas.
+func putVarbitInt(b *bstream, val int64) {
+	uval := zigZagEncode(val)
+	for uval >= 0x80 {
+		b.writeByte(byte(uval) | 0x80)
+		uval >>= 7
+	}
+	b.writeByte(byte(uval))
+}
+
+// readVarbitInt reads a value written by putVarbitInt.
+func readVarbitInt(br *bstreamReader) (int64, error) {
+	var (
+		uval  uint64
+		shift uint
+	)
+	for {
+		byt, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		uval |= uint64(byt&0x7f) << shift
+		if byt&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	return zigZagDecode(uval), nil
+}
+
+// zigZagEncode maps a signed integer to an unsigned one so that numbers
+// with a small absolute value (whichever the sign) result in a small
+// encoded value, which is what makes the varint encoding above effective
+// for deltas and delta-of-deltas.
+func zigZagEncode(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func zigZagDecode(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
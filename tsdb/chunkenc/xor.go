@@ -0,0 +1,557 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The code in this file was largely written by Damian Gryski as part of
+// https://github.com/dgryski/go-tsz and is published under the license below.
+// It was modified to support timestamps with a millisecond resolution.
+
+package chunkenc
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// chunkCompactCapacityThreshold is the number of extra bytes a chunk's
+// backing array may have before Compact reallocates it down to size.
+const chunkCompactCapacityThreshold = 32
+
+// XORChunk holds XOR encoded sample data.
+//
+// Prometheus' timestamps are in milliseconds and, unlike Facebook's Gorilla
+// paper (which uses seconds), deltas-of-deltas rarely fit into a handful of
+// bits, so the bit-width classes used here are wider than Gorilla's.
+type XORChunk struct {
+	b bstream
+}
+
+// NewXORChunk returns a new chunk with XOR encoding.
+func NewXORChunk() *XORChunk {
+	b := make([]byte, 2, 128)
+	return &XORChunk{b: bstream{stream: b, count: 0}}
+}
+
+// Encoding implements the Chunk interface.
+func (c *XORChunk) Encoding() Encoding {
+	return EncXOR
+}
+
+// Bytes implements the Chunk interface.
+func (c *XORChunk) Bytes() []byte {
+	return c.b.bytes()
+}
+
+// NumSamples implements the Chunk interface.
+func (c *XORChunk) NumSamples() int {
+	return int(binary.BigEndian.Uint16(c.Bytes()))
+}
+
+// Compact implements the Chunk interface.
+func (c *XORChunk) Compact() {
+	if l := len(c.b.stream); cap(c.b.stream) > l+chunkCompactCapacityThreshold {
+		buf := make([]byte, l)
+		copy(buf, c.b.stream)
+		c.b.stream = buf
+	}
+}
+
+// Reset implements the Chunk interface.
+func (c *XORChunk) Reset(b []byte) {
+	c.b.stream = b
+	c.b.count = 0
+}
+
+// Appender implements the Chunk interface.
+func (c *XORChunk) Appender() (Appender, error) {
+	it := c.iterator(nil)
+
+	// To get an appender, we must know the state it would have if we had
+	// appended all existing data from scratch, so we iterate through the
+	// end and populate the appender from the iterator's current state.
+	for it.Next() != ValNone {
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	a := &xorAppender{
+		b:        &c.b,
+		t:        it.t,
+		v:        it.val,
+		tDelta:   it.tDelta,
+		leading:  it.leading,
+		trailing: it.trailing,
+	}
+	// Until the second sample is written, an appender's leading is still
+	// the 0xff it started with; the iterator can't recover that from the
+	// bits, so restore it here.
+	if binary.BigEndian.Uint16(a.b.bytes()) < 2 {
+		a.leading = 0xff
+	}
+	return a, nil
+}
+
+func (c *XORChunk) iterator(it Iterator) *xorIterator {
+	if xorIter, ok := it.(*xorIterator); ok {
+		xorIter.Reset(c.b.bytes())
+		return xorIter
+	}
+	return &xorIterator{
+		br:       newBReader(c.b.bytes()),
+		numTotal: c.NumSamples(),
+		t:        math.MinInt64,
+	}
+}
+
+// Iterator implements the Chunk interface.
+func (c *XORChunk) Iterator(it Iterator) Iterator {
+	return c.iterator(it)
+}
+
+type xorAppender struct {
+	b *bstream
+
+	t      int64
+	v      float64
+	tDelta uint64
+
+	leading  uint8
+	trailing uint8
+}
+
+func (a *xorAppender) Append(t int64, v float64) {
+	var tDelta uint64
+	num := binary.BigEndian.Uint16(a.b.bytes())
+
+	switch num {
+	case 0:
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, byt := range buf[:binary.PutVarint(buf, t)] {
+			a.b.writeByte(byt)
+		}
+		a.b.writeBits(math.Float64bits(v), 64)
+
+	case 1:
+		tDelta = uint64(t - a.t)
+
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, byt := range buf[:binary.PutUvarint(buf, tDelta)] {
+			a.b.writeByte(byt)
+		}
+		a.writeVDelta(v)
+
+	default:
+		tDelta = uint64(t - a.t)
+		dod := int64(tDelta - a.tDelta)
+
+		switch {
+		case dod == 0:
+			a.b.writeBit(zero)
+		case bitRange(dod, 14):
+			a.b.writeBits(0b10, 2)
+			a.b.writeBits(uint64(dod), 14)
+		case bitRange(dod, 17):
+			a.b.writeBits(0b110, 3)
+			a.b.writeBits(uint64(dod), 17)
+		case bitRange(dod, 20):
+			a.b.writeBits(0b1110, 4)
+			a.b.writeBits(uint64(dod), 20)
+		default:
+			a.b.writeBits(0b1111, 4)
+			a.b.writeBits(uint64(dod), 64)
+		}
+
+		a.writeVDelta(v)
+	}
+
+	a.t = t
+	a.v = v
+	binary.BigEndian.PutUint16(a.b.bytes(), num+1)
+
+	a.tDelta = tDelta
+}
+
+// bitRange reports whether x fits into nbits bits of a two's-complement
+// representation (with one bit of headroom, matching the Gorilla paper).
+func bitRange(x int64, nbits uint8) bool {
+	return -((1<<(nbits-1))-1) <= x && x <= 1<<(nbits-1)
+}
+
+func (a *xorAppender) writeVDelta(v float64) {
+	xorWriteValue(a.b, v, a.v, &a.leading, &a.trailing)
+}
+
+// xorWriteValue XOR-encodes newValue against currentValue, reusing the
+// previous leading/trailing zero-bit counts when they still cover the new
+// delta's significant bits.
+func xorWriteValue(b *bstream, newValue, currentValue float64, leading, trailing *uint8) {
+	delta := math.Float64bits(newValue) ^ math.Float64bits(currentValue)
+
+	if delta == 0 {
+		b.writeBit(zero)
+		return
+	}
+	b.writeBit(one)
+
+	newLeading := uint8(bits.LeadingZeros64(delta))
+	newTrailing := uint8(bits.TrailingZeros64(delta))
+
+	// Clamp number of leading zeros to avoid overflow when encoding.
+	if newLeading >= 32 {
+		newLeading = 31
+	}
+
+	if *leading != 0xff && newLeading >= *leading && newTrailing >= *trailing {
+		b.writeBit(zero)
+		b.writeBits(delta>>*trailing, 64-int(*leading)-int(*trailing))
+		return
+	}
+
+	*leading, *trailing = newLeading, newTrailing
+
+	b.writeBit(one)
+	b.writeBits(uint64(newLeading), 5)
+
+	sigbits := 64 - newLeading - newTrailing
+	b.writeBits(uint64(sigbits), 6)
+	b.writeBits(delta>>newTrailing, int(sigbits))
+}
+
+type xorIterator struct {
+	br       bstreamReader
+	numTotal int
+	numRead  int
+
+	t   int64
+	val float64
+
+	leading  uint8
+	trailing uint8
+
+	tDelta uint64
+	err    error
+
+	checkpoints []xorCheckpoint
+}
+
+// xorCheckpoint is decoder state captured every checkpointInterval samples,
+// see the comment on that constant.
+type xorCheckpoint struct {
+	br      bstreamReader
+	numRead int
+
+	t   int64
+	val float64
+
+	leading  uint8
+	trailing uint8
+	tDelta   uint64
+}
+
+func (it *xorIterator) Seek(t int64) ValueType {
+	if it.err != nil {
+		return ValNone
+	}
+
+	for t > it.t || it.numRead == 0 {
+		if it.Next() == ValNone {
+			return ValNone
+		}
+	}
+	return ValFloat
+}
+
+// Prev implements the Iterator interface.
+func (it *xorIterator) Prev() ValueType {
+	if it.err != nil || it.numRead == 0 {
+		return ValNone
+	}
+	idx := it.numRead - 2
+	if idx < 0 {
+		it.resetDecode()
+		return ValNone
+	}
+	return it.seekToIndex(idx)
+}
+
+// SeekBack implements the Iterator interface.
+func (it *xorIterator) SeekBack(t int64) ValueType {
+	if it.err != nil {
+		return ValNone
+	}
+	if it.numRead > 0 && it.t <= t {
+		return ValFloat
+	}
+
+	start := 0
+	for _, cp := range it.checkpoints {
+		if cp.t > t {
+			break
+		}
+		start = cp.numRead - 1
+	}
+	if it.seekToIndex(start) == ValNone {
+		return ValNone
+	}
+	if it.t > t {
+		return it.Prev()
+	}
+
+	last := it.numRead - 1
+	for {
+		if it.Next() == ValNone || it.t > t {
+			break
+		}
+		last = it.numRead - 1
+	}
+	if last != it.numRead-1 {
+		return it.seekToIndex(last)
+	}
+	return ValFloat
+}
+
+// resetDecode rewinds the iterator to before the first sample.
+func (it *xorIterator) resetDecode() {
+	it.br = newBReader(it.br.stream)
+	it.numRead = 0
+	it.t = math.MinInt64
+	it.val = 0
+	it.leading = 0
+	it.trailing = 0
+	it.tDelta = 0
+	it.err = nil
+}
+
+// seekToIndex moves to the 0-based sample index idx (which must be within
+// [0, numTotal)), restoring the nearest checkpoint at or before idx and
+// replaying forward with Next from there.
+func (it *xorIterator) seekToIndex(idx int) ValueType {
+	best := -1
+	for i, cp := range it.checkpoints {
+		if cp.numRead-1 > idx {
+			break
+		}
+		best = i
+	}
+	if best >= 0 {
+		cp := it.checkpoints[best]
+		it.br = cp.br
+		it.numRead = cp.numRead
+		it.t = cp.t
+		it.val = cp.val
+		it.leading = cp.leading
+		it.trailing = cp.trailing
+		it.tDelta = cp.tDelta
+		it.err = nil
+	} else {
+		it.resetDecode()
+	}
+	for it.numRead-1 < idx {
+		if it.Next() == ValNone {
+			return ValNone
+		}
+	}
+	return ValFloat
+}
+
+// maybeCheckpoint appends a checkpoint for the sample just decoded, every
+// checkpointInterval samples, unless an earlier pass already recorded it.
+func (it *xorIterator) maybeCheckpoint() {
+	if it.numRead%checkpointInterval != 0 || len(it.checkpoints) >= it.numRead/checkpointInterval {
+		return
+	}
+	it.checkpoints = append(it.checkpoints, xorCheckpoint{
+		br:       it.br,
+		numRead:  it.numRead,
+		t:        it.t,
+		val:      it.val,
+		leading:  it.leading,
+		trailing: it.trailing,
+		tDelta:   it.tDelta,
+	})
+}
+
+func (it *xorIterator) At() (int64, float64) {
+	return it.t, it.val
+}
+
+func (it *xorIterator) Err() error {
+	return it.err
+}
+
+func (it *xorIterator) Reset(b []byte) {
+	it.br = newBReader(b)
+	it.numTotal = int(binary.BigEndian.Uint16(b))
+
+	it.numRead = 0
+	it.t = math.MinInt64
+	it.val = 0
+	it.leading = 0
+	it.trailing = 0
+	it.tDelta = 0
+	it.err = nil
+	it.checkpoints = it.checkpoints[:0]
+}
+
+func (it *xorIterator) Next() ValueType {
+	if it.err != nil || it.numRead == it.numTotal {
+		return ValNone
+	}
+
+	if it.numRead == 0 {
+		// The first 2 bytes hold the sample count, skip over them.
+		if _, err := it.br.ReadByte(); err != nil {
+			it.err = err
+			return ValNone
+		}
+		if _, err := it.br.ReadByte(); err != nil {
+			it.err = err
+			return ValNone
+		}
+		t, err := binary.ReadVarint(&it.br)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		v, err := it.br.readBits(64)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		it.t = t
+		it.val = math.Float64frombits(v)
+
+		it.numRead++
+		it.maybeCheckpoint()
+		return ValFloat
+	}
+	if it.numRead == 1 {
+		tDelta, err := binary.ReadUvarint(&it.br)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		it.tDelta = tDelta
+		it.t += int64(it.tDelta)
+
+		return it.readValue()
+	}
+
+	var d byte
+	// Read value of the "control bit" by reading successive bits until
+	// we either hit a 0 or 4 ones.
+	for i := 0; i < 4; i++ {
+		d <<= 1
+		bitv, err := it.br.readBit()
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		if bitv == zero {
+			break
+		}
+		d |= 1
+	}
+	var sz uint8
+	var dod int64
+	switch d {
+	case 0b0:
+		// dod == 0
+	case 0b10:
+		sz = 14
+	case 0b110:
+		sz = 17
+	case 0b1110:
+		sz = 20
+	case 0b1111:
+		bits, err := it.br.readBits(64)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		dod = int64(bits)
+	}
+
+	if sz != 0 {
+		bits, err := it.br.readBits(sz)
+		if err != nil {
+			it.err = err
+			return ValNone
+		}
+		if bits > (1 << (sz - 1)) {
+			// Or something.
+			bits -= 1 << sz
+		}
+		dod = int64(bits)
+	}
+
+	it.tDelta = uint64(int64(it.tDelta) + dod)
+	it.t += int64(it.tDelta)
+
+	return it.readValue()
+}
+
+func (it *xorIterator) readValue() ValueType {
+	err := xorReadValue(&it.val, &it.br, &it.leading, &it.trailing)
+	if err != nil {
+		it.err = err
+		return ValNone
+	}
+	it.numRead++
+	it.maybeCheckpoint()
+	return ValFloat
+}
+
+// xorReadValue is the decoding counterpart of xorWriteValue.
+func xorReadValue(value *float64, br *bstreamReader, leading, trailing *uint8) error {
+	bitv, err := br.readBit()
+	if err != nil {
+		return err
+	}
+
+	if bitv == zero {
+		return nil
+	}
+
+	bitv, err = br.readBit()
+	if err != nil {
+		return err
+	}
+	if bitv != zero {
+		lbits, err := br.readBits(5)
+		if err != nil {
+			return err
+		}
+		mbits, err := br.readBits(6)
+		if err != nil {
+			return err
+		}
+		*leading = uint8(lbits)
+
+		mbitsInt := mbits
+		if mbitsInt == 0 {
+			mbitsInt = 64
+		}
+		*trailing = 64 - uint8(*leading) - uint8(mbitsInt)
+	}
+	mbits := 64 - *leading - *trailing
+	bits, err := br.readBits(mbits)
+	if err != nil {
+		return err
+	}
+	vbits := math.Float64bits(*value)
+	vbits ^= bits << *trailing
+	*value = math.Float64frombits(vbits)
+	return nil
+}